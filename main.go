@@ -1,945 +1,347 @@
 package main
 
 import (
-	"encoding/binary"
+	"embed"
+	"flag"
 	"fmt"
-	"image/color"
-	"io"
+	"io/fs"
 	"log"
 	"log/slog"
-	"math"
 	"os"
 	"path"
-	"sort"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
 	"strings"
-	"time"
 
-	_ "embed"
-
-	"github.com/hajimehoshi/ebiten/v2"
-	"github.com/hajimehoshi/ebiten/v2/audio"
-	"github.com/hajimehoshi/ebiten/v2/audio/mp3"
-	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
-	"github.com/hajimehoshi/ebiten/v2/inpututil"
-	"github.com/hajimehoshi/ebiten/v2/vector"
-	"golang.org/x/image/colornames"
+	"midivis/midivis"
 )
 
-//go:embed shaders/radialblur.kage
-var radialblur_kage []byte
-
-//go:embed shaders/colormod.kage
-var colormod_kage []byte
-
-//go:embed shaders/radialgradient.kage
-var radialgradient_kage []byte
-
-const width = 1024
-const height = 768
-
-// TODO: Hardcoded for now, but we can get from midi as a tempo type event
-const microSecondsPerQuarterNote = 375000
-
-const debug = false
-
-type MidiNoteType byte
-
+// Default song, embedded so the binary runs without any files. Files on disk take precedence
 const (
-	NoteOff MidiNoteType = 0x8
-	NoteOn  MidiNoteType = 0x9
+	defaultMidiDir   = "ag"
+	defaultAudioFile = "A. G. Cook - Idyll.mp3"
 )
 
-type MidiNote struct {
-	deltaTime int
-	eventType MidiNoteType
-	channel   byte
-	note      byte
-	velocity  byte
-}
-
-type MidiTrack struct {
-	notes []MidiNote
-	// PPQN is the number of ticks per quarter note
-	// It is pulled from midi header (division)
-	ppqn uint16
-}
-
-type Note struct {
-	on  int
-	off int
-	num int
-	str string
-	vel int
-}
-
-type Track struct {
-	name  string
-	ppqn  uint16
-	bpm   int
-	notes []Note
-}
-
-const (
-	NoteTypeRect = iota
-	NoteTypeScreen
-	NoteTypeMeter
-	NoteTypeZoom
-	NoteTypeRadialGradient
-)
-
-var noteTypes = []int{
-	NoteTypeRect,
-	NoteTypeScreen,
-	NoteTypeMeter,
-	NoteTypeZoom,
-	NoteTypeRadialGradient,
-}
-
-// Map midi files to animation types
-var fileNameToType = map[string]int{
-	"ah.mid":                NoteTypeRadialGradient,
-	"bridgevocalbottom.mid": NoteTypeRect,
-	"bridgevocaltop.mid":    NoteTypeRect,
-	"cleanvocalsbottom.mid": NoteTypeRect,
-	"click.mid":             NoteTypeRadialGradient,
-	"cymbalend.mid":         NoteTypeMeter,
-	"endbass.mid":           NoteTypeZoom,
-	"flutevoice.mid":        NoteTypeZoom,
-	"introvocals.mid":       NoteTypeRect,
-	"kick.mid":              NoteTypeRadialGradient,
-	"mainvocaltop.mid":      NoteTypeRect,
-	"oohchords.mid":         NoteTypeZoom,
-	"plucky.mid":            NoteTypeRect,
-	"shew.mid":              NoteTypeZoom,
-	"shimmery.mid":          NoteTypeRect,
-	"shimmeryfast.mid":      NoteTypeRect,
-	"shimmeryfastbass.mid":  NoteTypeZoom,
-	"slidey.mid":            NoteTypeZoom,
-}
-
-type RenderableNoteBase struct {
-	Note
-	z int // z-index, used for rendering order
-}
-
-// NoteRect animates a rectangle across the screen during play
-type NoteRect struct {
-	RenderableNoteBase
-	xScale float64
-	color  *color.RGBA
-}
-
-// NoteScreen fills entire screen with color during play
-type NoteScreen struct {
-	RenderableNoteBase
-	color *color.RGBA
-}
-
-// NoteMeter animates a rectangle from left to right during play filling screen
-type NoteMeter struct {
-	RenderableNoteBase
-	color *color.RGBA
-}
-
-// NoteZoom animates a rectangle from center to full width during play
-type NoteZoom struct {
-	RenderableNoteBase
-	color *color.RGBA
-}
-
-// NoteRadialGradient animates a radial gradient from center to full width during play
-type NoteRadialGradient struct {
-	RenderableNoteBase
-	color *color.RGBA
-}
-
-type Renderable interface {
-	GetZ() int
-	Draw(screen *ebiten.Image, g *Game)
-}
-
-func (o *RenderableNoteBase) GetZ() int {
-	return o.z
-}
-
-func (o *NoteRect) Draw(screen *ebiten.Image, g *Game) {
+//go:embed ag "A. G. Cook - Idyll.mp3"
+var defaultSong embed.FS
 
-	// Draw the object
-	noteY := g.noteHeight*(o.num-g.noteMin) + g.noteTopBottomPaddingPixels
-	// flip b/c we draw from upper left corner
-	noteY = height - noteY
-
-	isBeingPlayed := o.on <= g.elapsedDeltaTime && g.elapsedDeltaTime <= o.off
-
-	// set arbitrary velocity minimum and scale from there
-	velMin := 100
-	velRange := 127 - velMin
-	xScaleVel := ((velMin - o.vel) / velRange) + 1
-	noteX := float32(o.on-g.elapsedDeltaTime)*float32(xScaleVel) + float32(g.xTranslate)
-	noteWidth := float32(o.off-o.on) * float32(xScaleVel)
-	if isBeingPlayed {
-		vector.DrawFilledRect(screen, noteX, float32(noteY), noteWidth, float32(g.noteHeight), o.color, true)
-
-		// set the blur Y position to the note's Y position
-		g.radialBlurShaderOpts.Uniforms["Center"] = []float32{float32(width) / 2.0, float32(noteY)}
-	} else {
-		strokeWidth := float32(1)
-		vector.StrokeRect(screen, noteX, float32(noteY), noteWidth, float32(g.noteHeight), strokeWidth, o.color, true)
-	}
-}
-
-func (o *NoteScreen) Draw(screen *ebiten.Image, g *Game) {
-	// cover screen with color
-	isBeingPlayed := o.on <= g.elapsedDeltaTime && g.elapsedDeltaTime <= o.off
-	if isBeingPlayed {
-		vector.DrawFilledRect(screen, 0, 0, float32(width), float32(height), o.color, true)
-	}
-}
-
-func (o *NoteMeter) Draw(screen *ebiten.Image, g *Game) {
-	// zoom in from small to large, filling up width of screen when being played
-	deltaThreshold := g.ppqn
-
-	// hasn't started
-	if o.on-deltaThreshold > g.elapsedDeltaTime {
-		return
-	}
-
-	// already finished
-	if o.off < g.elapsedDeltaTime {
-		return
-	}
-
-	isBeingPlayed := o.on <= g.elapsedDeltaTime && g.elapsedDeltaTime <= o.off
-	if isBeingPlayed {
-		noteX := float32(0)
-		// noteY := o.num * g.noteHeight
-		// Draw the object
-		noteY := g.noteHeight*(o.num-g.noteMin) + g.noteTopBottomPaddingPixels
-		// flip b/c we draw from upper left corner
-		noteY = height - noteY
-
-		pctUntilPlayStarts := float32(g.elapsedDeltaTime-o.on) / float32(deltaThreshold)
-		// flip it
-		pctUntilPlayStarts = 1 - pctUntilPlayStarts
-		// width goes from 0 to width of screen
-		noteWidth := width * pctUntilPlayStarts
-		vector.DrawFilledRect(screen, noteX, float32(noteY), noteWidth, float32(g.noteHeight), o.color, true)
-	}
-}
-
-func (o *NoteZoom) Draw(screen *ebiten.Image, g *Game) {
-	// zoom in from small to large, filling up width of screen when being played
-	deltaThreshold := g.ppqn * 2
-
-	// hasn't started
-	if o.on-deltaThreshold > g.elapsedDeltaTime {
-		return
-	}
-
-	// already finished
-	if o.off < g.elapsedDeltaTime {
-		return
-	}
-
-	tUntilOn := max(float32(o.on-g.elapsedDeltaTime), 0.0)
-	pctUntilPlayStarts := tUntilOn / float32(deltaThreshold)
-	// flip it, so 0 is at beginning of threshold, 1 as at note on
-	pctUntilPlayStarts = 1 - pctUntilPlayStarts
-
-	// x is between 0 and width / 2
-	noteX := float32(width) / 2 * pctUntilPlayStarts
-	// flip x so it goes from width / 2 to 0
-	noteX = float32(width)/2 - noteX
-	distToMiddle := float32(width)/2 - noteX
-	noteWidth := distToMiddle * 2
-
-	noteY := g.noteHeight*(o.num-g.noteMin) + g.noteTopBottomPaddingPixels
-	// flip b/c we draw from upper left corner
-	noteY = height - noteY
-
-	noteHeight := float32(g.noteHeight) * pctUntilPlayStarts
-
-	isBeingPlayed := o.on <= g.elapsedDeltaTime && g.elapsedDeltaTime <= o.off
-	if isBeingPlayed {
-		vector.DrawFilledRect(screen, noteX, float32(noteY), noteWidth, noteHeight, o.color, true)
-	} else {
-		strokeWidth := float32(1)
-		vector.StrokeRect(screen, noteX, float32(noteY), noteWidth, noteHeight, strokeWidth, o.color, true)
-	}
-}
-
-func (o *NoteRadialGradient) Draw(screen *ebiten.Image, g *Game) {
-	isBeingPlayed := o.on <= g.elapsedDeltaTime && g.elapsedDeltaTime <= o.off
-	alreadyHandled := g.radialGradientShaderOpts.Uniforms["PctShow"] != 0
-
-	if !isBeingPlayed || alreadyHandled {
-		return
-	}
-
-	pctShow := float32(g.elapsedDeltaTime-o.on) / float32(o.off-o.on)
-	g.radialGradientShaderOpts.Uniforms["PctShow"] = 1 - pctShow
-	g.radialGradientShaderOpts.Uniforms["Color"] = []float32{float32(o.color.R), float32(o.color.G), float32(o.color.B), float32(o.color.A)}
-}
-
-type Game struct {
-	currentTick                int64
-	elapsedDeltaTime           int
-	playerMeasure              int
-	ppqn                       int
-	tracks                     []*Track
-	notes                      []Renderable
-	noteMin                    int
-	noteHeight                 int
-	noteTopBottomPaddingPixels int
-	xTranslate                 float64
-
-	shader               *ebiten.Shader
-	radialBlurShaderOpts *ebiten.DrawRectShaderOptions
-
-	colormodShader *ebiten.Shader
-
-	radialGradientShader     *ebiten.Shader
-	radialGradientShaderOpts *ebiten.DrawRectShaderOptions
-
-	playerPosition time.Duration
-	player         *audio.Player
-}
-
-func (g *Game) Update() error {
-	if g.player.IsPlaying() {
-		g.playerPosition = g.player.Position()
-		g.elapsedDeltaTime = secondsToDeltaTime(float64(g.playerPosition.Milliseconds())/1000.0, microSecondsPerQuarterNote, g.ppqn)
-	} else {
-		// If not playing, just use ticks to track time
-		g.currentTick++
-		// convert screen render ticks (g.currentTick) to midi ticks
-		// Each screen tick is assumed to be 1/60th of a second, probably need to fix this later
-		g.elapsedDeltaTime = secondsToDeltaTime(float64(g.currentTick)*(1.0/60.0), microSecondsPerQuarterNote, g.ppqn)
-
-	}
-
-	g.playerMeasure = g.elapsedDeltaTime / (g.ppqn * 4)
-
-	// if right key just released, seek a bit
-	if inpututil.IsKeyJustPressed(ebiten.KeyRight) {
-		err := g.seekToMeasure(g.playerMeasure + 1)
-
-		if err != nil {
+func main() {
+	opts := midivis.Options{}
+	midiDir := flag.String("midi-dir", defaultMidiDir, "directory of the midi files to render, the embedded song's are used if the default doesn't exist")
+	flag.StringVar(&opts.AudioFile, "audio", defaultAudioFile, "mp3, wav or ogg file played along with the midi files, the embedded song's is used if the default doesn't exist")
+	flag.Float64Var(&opts.AudioStart, "audio-start", 0, "seconds into the audio file the midi starts, skipping silence or a count-in at its start")
+	flag.StringVar(&opts.AudioFormat, "audio-format", midivis.AudioFormatF32, "sample format the audio is decoded to, f32 or s16 which is lighter on constrained hardware")
+	flag.BoolVar(&opts.Debug, "debug", false, "log parser details and print the player position on screen")
+	flag.IntVar(&opts.FPS, "fps", 60, "number of updates per second")
+	flag.IntVar(&opts.Transpose, "transpose", 0, "shift the pitch of every note by this many semitones")
+	flag.IntVar(&opts.Upsample, "upsample", 1, "multiply the ppqn and note ticks by this for smoother animations of low ppqn files")
+	flag.Float64Var(&opts.ShakeIntensity, "shake-intensity", 0, "max camera shake offset in pixels, 0 disables shaking")
+	flag.Float64Var(&opts.ShakeDecay, "shake-decay", 0.9, "multiplier applied to the camera shake every frame")
+	flag.StringVar(&opts.ShakeFile, "shake-file", "kick.mid", "midi file whose notes trigger the camera shake")
+	flag.IntVar(&opts.ShakeVelocity, "shake-velocity", 100, "minimum note velocity that triggers the camera shake")
+	flag.Float64Var(&opts.PanWidth, "pan-width", 0, "max horizontal offset in pixels of notes on hard panned channels, 0 ignores pan")
+	flag.BoolVar(&opts.PreviewOutlines, "preview-outlines", false, "draw a faint outline of upcoming notes for renderers that only draw while playing")
+	flag.BoolVar(&opts.QuantizePreview, "quantize-preview", false, "draw a ghost of each note snapped to the nearest 16th note")
+	flag.IntVar(&opts.QuantizeDisplay, "quantize-display", 0, "draw notes snapped to the nearest Nth note, e.g. 16, without changing when they play. 0 draws exact ticks")
+	flag.BoolVar(&opts.Waveform, "waveform", false, "draw the audio waveform along the bottom of the screen, click it to seek")
+	flag.BoolVar(&opts.NoBlur, "no-blur", false, "draw the notes directly, skipping the blur and radial gradient shaders")
+	flag.Float64Var(&opts.BlurStrength, "blur-strength", 1, "strength of the radial blur, scales how far it smears the notes")
+	flag.Float64Var(&opts.BlurSensitivity, "blur-sensitivity", 0, "blur strength added for every full velocity note playing, so the blur pulses with the music")
+	flag.BoolVar(&opts.Spectrum, "spectrum", false, "draw the frequency spectrum of the audio as faint bars behind the notes")
+	flag.BoolVar(&opts.Minimap, "minimap", false, "draw an overview of the whole song in the top right corner, click it to seek")
+	flag.BoolVar(&opts.Lanes, "lanes", false, "draw each track in its own band of the screen fit to its note range, so tracks don't overlap")
+	flag.BoolVar(&opts.VUMeter, "vu-meter", false, "draw a meter of the total velocity of the playing notes along the right edge")
+	flag.BoolVar(&opts.HSVPalette, "hsv-palette", false, "color tracks with evenly spaced hues when there are more tracks than default colors")
+	flag.IntVar(&opts.PaletteColors, "palette-colors", 0, "number of hues in the HSV palette, 0 uses one per track")
+	flag.Int64Var(&opts.Seed, "seed", 0, "seed of the random color jitter and camera shake, 0 picks a random seed")
+	flag.Float64Var(&opts.ColorJitter, "color-jitter", 0, "randomly shift the hue and brightness of each note's color by up to this amount, from 0 to 1")
+	flag.BoolVar(&opts.ChannelLabels, "channel-labels", false, "draw a legend of each channel's color and instrument")
+	flag.Float64Var(&opts.CornerRadius, "radius", 0, "corner radius of the note rects in pixels, 0 keeps them square")
+	flag.Float64Var(&opts.Gap, "gap", 0, "vertical space in pixels between notes of adjacent pitches")
+	flag.BoolVar(&opts.HUD, "hud", false, "show the measure:beat:tick, tempo, time and playing notes in the bottom left corner, toggle it with H")
+	flag.BoolVar(&opts.MeasureNumbers, "measure-numbers", false, "draw measure numbers along the top of the screen, scrolling with the notes")
+	flag.Float64Var(&opts.FadeFinished, "fade-finished", 0, "fade out finished rect notes over this many beats then hide them, 0 keeps drawing them")
+	flag.IntVar(&opts.AccentThreshold, "accent-threshold", 0, "emphasize notes whose velocity is more than this above the track's running average, 0 disables accents")
+	flag.Float64Var(&opts.MinNoteBeats, "min-note-beats", 1.0/64, "minimum length in beats of notes, shorter notes such as zero length ones are lengthened to it")
+	flag.Float64Var(&opts.PixelsPerBeat, "pixels-per-beat", 0, "scroll speed of the notes in pixels per beat, 0 scrolls a pixel per tick")
+	flag.BoolVar(&opts.Ties, "ties", false, "connect rect notes that start as the previous note of their track ends, showing legato phrases")
+	flag.IntVar(&opts.Echoes, "echoes", 0, "number of fading copies drawn behind playing rect notes where they just were, 0 disables them")
+	flag.Float64Var(&opts.EchoDecay, "echo-decay", 0.5, "alpha multiplied into each echo, from 0 to 1")
+	flag.Float64Var(&opts.MinNoteSize, "min-note-size", 2, "minimum width and height in pixels of drawn notes, so very short notes stay visible")
+	flag.Float64Var(&opts.StrokeWidth, "stroke", 1, "width in pixels of the outlines of notes that aren't playing")
+	flag.BoolVar(&opts.Mirror, "mirror", false, "also draw every note reflected across the horizontal midline")
+	flag.StringVar(&opts.Title, "title", "midivis", "window title")
+	flag.BoolVar(&opts.TitlePosition, "title-position", false, "show the current measure and time in the window title")
+	flag.Float64Var(&opts.DemoIdle, "demo", 0, "seconds without input before switching note types and skipping ahead, for unattended displays. 0 disables it")
+	flag.StringVar(&opts.ExportDir, "export", "", "write every frame to a numbered png in this directory instead of playing the audio")
+	flag.IntVar(&opts.GridSnap, "grid-snap", 0, "export exactly this many frames per beat so the frames loop on beats, 0 exports at -fps")
+	flag.StringVar(&opts.OSCAddress, "osc", "", "host:port to send /note/on and /note/off OSC messages to, timed to the playhead")
+	flag.BoolVar(&opts.Stretch, "stretch", false, "stretch the screen over the window when their aspect ratios differ instead of letterboxing")
+	flag.Float64Var(&opts.TargetFPS, "target-fps", 0, "lower the render quality while the FPS is below this, 0 disables adaptive quality")
+	flag.Func("size", "logical screen size as WxH, e.g. 1080x1920 for vertical video", func(s string) error {
+		w, h, ok := strings.Cut(s, "x")
+		if !ok {
+			return fmt.Errorf("expected WxH")
+		}
+		var err error
+		if opts.Width, err = strconv.Atoi(w); err != nil || opts.Width <= 0 {
+			return fmt.Errorf("invalid width %q", w)
+		}
+		if opts.Height, err = strconv.Atoi(h); err != nil || opts.Height <= 0 {
+			return fmt.Errorf("invalid height %q", h)
+		}
+		return nil
+	})
+	flag.Func("breaks", "comma separated measures where playback pauses until space is pressed, e.g. 16,32,48", func(s string) error {
+		for _, measure := range strings.Split(s, ",") {
+			m, err := strconv.Atoi(strings.TrimSpace(measure))
+			if err != nil {
+				return fmt.Errorf("invalid measure %q", measure)
+			}
+			opts.Breakpoints = append(opts.Breakpoints, m)
+		}
+		return nil
+	})
+	flag.Func("loop-range", "loop the measures from A up to B, e.g. 16:24", func(s string) error {
+		start, end, ok := strings.Cut(s, ":")
+		if !ok {
+			return fmt.Errorf("expected A:B")
+		}
+		var err error
+		if opts.LoopStart, err = strconv.Atoi(start); err != nil {
+			return fmt.Errorf("invalid start measure %q", start)
+		}
+		if opts.LoopEnd, err = strconv.Atoi(end); err != nil {
+			return fmt.Errorf("invalid end measure %q", end)
+		}
+		if opts.LoopEnd <= opts.LoopStart {
+			return fmt.Errorf("end measure must be after the start measure")
+		}
+		return nil
+	})
+	flag.Func("range", "lowest and highest notes displayed as numbers or names, e.g. 21:108 or A1:C9, overrides normalize", func(s string) error {
+		low, high, ok := strings.Cut(s, ":")
+		if !ok {
+			return fmt.Errorf("expected low:high")
+		}
+		var err error
+		if opts.NoteRangeLow, err = midivis.ParseNote(low); err != nil {
 			return err
 		}
+		if opts.NoteRangeHigh, err = midivis.ParseNote(high); err != nil {
+			return err
+		}
+		if opts.NoteRangeHigh <= opts.NoteRangeLow {
+			return fmt.Errorf("high note must be above the low note")
+		}
+		return nil
+	})
+	only := flag.String("only", "", "only render this midi file from the directory, e.g. kick.mid")
+	check := flag.Bool("check", false, "parse every midi file, print the problems found in each and exit, failing if any file failed to parse")
+	list := flag.Bool("list", false, "print the tempo and time signature changes of each track and exit without opening a window")
+	notesFileName := flag.String("notes", "", "also render the notes of this csv file with rows of note,on_tick,off_tick,velocity,channel")
+	csvFileName := flag.String("csv", "", "write the notes to this csv file and exit without opening a window")
+	compareFileName := flag.String("compare", "", "also draw the notes of this midi file faintly on top, to compare a transcription against a reference")
+	configFileName := flag.String("config", "", "json file describing how each midi file is rendered")
+	cpuProfileFileName := flag.String("cpuprofile", "", "write a cpu profile to this file, stopped when the window is closed or Escape is pressed")
+	traceFileName := flag.String("trace", "", "write an execution trace to this file, stopped when the window is closed or Escape is pressed")
+	flag.Parse()
+
+	if opts.FPS <= 0 {
+		log.Fatalf("-fps must be positive, got %d", opts.FPS)
+	}
+	if opts.Upsample < 1 {
+		log.Fatalf("-upsample must be at least 1, got %d", opts.Upsample)
 	}
 
-	// Update shader uniforms
-	g.radialGradientShaderOpts.Uniforms["PctShow"] = 0
-
-	cx, cy := ebiten.CursorPosition()
-	g.radialBlurShaderOpts.Uniforms["Time"] = float32(g.currentTick) / 60
-	g.radialBlurShaderOpts.Uniforms["Cursor"] = []float32{float32(cx), float32(cy)}
-
-	return nil
-}
-
-// seekToTime seeks to a specific time in the audio file
-func (g *Game) seekToTime(t time.Duration) error {
-	if err := g.player.SetPosition(t); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-// seekToMeasure seeks to a specific measure in the audio file
-func (g *Game) seekToMeasure(m int) error {
-	deltaTime := m * g.ppqn * 4
-	t := deltaTimeToSeconds(deltaTime, microSecondsPerQuarterNote, g.ppqn)
-	nanoSec := int64(t * 1000000000)
-	if err := g.seekToTime(time.Duration(nanoSec)); err != nil {
-		return err
+	loggerLevel := slog.LevelInfo
+	if opts.Debug {
+		loggerLevel = slog.LevelDebug
 	}
+	loggerOpts := &slog.HandlerOptions{Level: loggerLevel}
+	opts.Logger = slog.New(slog.NewTextHandler(os.Stdout, loggerOpts))
 
-	return nil
-}
-
-func (g *Game) Draw(screen *ebiten.Image) {
-
-	baseImage := ebiten.NewImage(width, height)
-	for _, note := range g.notes {
-		note.Draw(baseImage, g)
+	if *configFileName != "" {
+		config, err := midivis.LoadRenderConfig(*configFileName)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Config = config
 	}
 
-	blurImage := ebiten.NewImage(width, height)
-	blurImage.DrawRectShader(width, height, g.shader, g.radialBlurShaderOpts)
-
-	g.radialBlurShaderOpts.Images[0] = baseImage
-	g.radialGradientShaderOpts.Images[0] = blurImage
-
-	screen.DrawRectShader(width, height, g.radialGradientShader, g.radialGradientShaderOpts)
-
-	measurePosition := g.elapsedDeltaTime / (g.ppqn * 4)
-	if debug {
-		ebitenutil.DebugPrint(screen, fmt.Sprintf("playerPosition: %d\nmeasurePosition: %d", g.playerPosition, measurePosition))
+	// fall back to the embedded song when the default files aren't on disk
+	if _, err := os.Stat(*midiDir); err != nil && *midiDir == defaultMidiDir {
+		opts.FS = defaultSong
 	}
-}
-
-func (g *Game) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
-	return width, height
-}
-
-func check(e error) {
-	if e != nil {
-		panic(e)
+	if _, err := os.Stat(opts.AudioFile); err != nil && opts.AudioFile == defaultAudioFile {
+		opts.AudioFS = defaultSong
 	}
-}
 
-func noteNumberToString(noteNumber byte) string {
-	notes := []string{
-		"C",
-		"C#",
-		"D",
-		"D#",
-		"E",
-		"F",
-		"F#",
-		"G",
-		"G#",
-		"A",
-		"A#",
-		"B",
+	if info, err := statFile(opts.FS, *midiDir); err != nil || !info.IsDir() {
+		fmt.Fprintf(flag.CommandLine.Output(), "-midi-dir %q isn't a directory\n", *midiDir)
+		flag.Usage()
+		os.Exit(2)
 	}
-	octave := int(noteNumber / 12)
-	note := int(noteNumber % 12)
-	return fmt.Sprintf("%s%d", notes[note], octave)
-}
 
-func readVariableLengthValue2(dat io.Reader) (result int) {
-	result = 0
-	for {
-		b := make([]byte, 1)
-		_, err := dat.Read(b)
-		check(err)
-		result = (result << 7) | int(b[0]&0x7F)
-		if b[0]&0x80 == 0 {
-			break
+	mappingFileName := path.Join(*midiDir, midivis.TypeMappingFileName)
+	if _, err := statFile(opts.FS, mappingFileName); err == nil {
+		mapping, err := midivis.LoadTypeMapping(opts.FS, mappingFileName)
+		if err != nil {
+			log.Fatal(err)
 		}
+		opts.TypeMapping = mapping
 	}
 
-	return result
-}
-
-func NewMidiTrack() *MidiTrack {
-
-	return &MidiTrack{
-		notes: []MidiNote{},
-		ppqn:  0,
-	}
-}
+	vis := midivis.New(opts)
 
-func NewTrack(fileName string, ppqn uint16) *Track {
-
-	return &Track{
-		name:  path.Base(fileName),
-		notes: []Note{},
-		ppqn:  ppqn,
+	if *only != "" {
+		if _, err := statFile(opts.FS, path.Join(*midiDir, *only)); err != nil {
+			log.Fatalf("-only: %v", err)
+		}
 	}
-}
-
-func parseMidiFile(logger *slog.Logger, fileName string) *MidiTrack {
-	// Reference: https://midimusic.github.io/tech/midispec.html
-	dat, err := os.Open(fileName)
-	check(err)
-	defer dat.Close()
-	midiTrack := NewMidiTrack()
 
-	// first 4 bytes (32 bits) are the header type in ascii
-	headerBytes := make([]byte, 4)
-	_, err = dat.Read(headerBytes)
-	check(err)
-	logger.Info("Header Type:", string(headerBytes))
-
-	// length is the next 4 bytes (32 bits) in big endian
-	lengthBytes := make([]byte, 4)
-	_, err = dat.Read(lengthBytes)
-	lengthInt := binary.BigEndian.Uint32(lengthBytes)
-	logger.Info("Length:", lengthInt)
-
-	// -- Data Section --
-	// format is the next 2 bytes (16 bits) in big endian
-	formatBytes := make([]byte, 2)
-	_, err = dat.Read(formatBytes)
-	formatInt := binary.BigEndian.Uint16(formatBytes)
-	logger.Info("Format:", formatInt)
-	if formatInt != 0 {
-		panic("Format not supported")
+	files, err := readDir(opts.FS, *midiDir)
+	if err != nil {
+		panic(err)
 	}
 
-	// ntracks is the next 2 bytes (16 bits) in big endian
-	nTracksBytes := make([]byte, 2)
-	_, err = dat.Read(nTracksBytes)
-	nTracksInt := binary.BigEndian.Uint16(nTracksBytes)
-	logger.Info("NTracks:", nTracksInt)
-
-	// division is the next 2 bytes (16 bits) in big endian
-	// if the first bit is 0, the remaining 15 bits represent the number of ticks quarter note
-	//   For instance, if division is 96, then a time interval of an eighth-note between two events in the file would be 48
-	// if the first bit is 1, the remaining 15 bits represent the number of ticks per frame
-	divisionTypeBytes := make([]byte, 2)
-	_, err = dat.Read(divisionTypeBytes)
-	logger.Info("Division Type:", divisionTypeBytes[0])
-
-	if divisionTypeBytes[0]&0x80 == 0 {
-		division := binary.BigEndian.Uint16(divisionTypeBytes)
-		logger.Info("Division (Ticks per Quarter Note):", division)
-		midiTrack.ppqn = division
-	} else {
-		// just panic for now
-		panic("Division Type not supported")
+	filePaths := []string{}
+	for _, file := range files {
+		isMidi := strings.HasSuffix(file.Name(), ".mid") || strings.HasSuffix(file.Name(), ".mid.gz")
+		if file.IsDir() || !isMidi {
+			continue
+		}
+		if *only != "" && file.Name() != *only {
+			continue
+		}
+		filePaths = append(filePaths, path.Join(*midiDir, file.Name()))
 	}
 
-	// -- Track Section --
-	// The format for Track Chunks (described below) is exactly the same for all three formats (0, 1, and 2: see "Header Chunk" above) of MIDI Files.
-	// <Track Chunk> = <chunk type><length><MTrk event>+
-	// track header is the next 4 bytes (32 bits) in ascii
-	trackHeaderBytes := make([]byte, 4)
-	_, err = dat.Read(trackHeaderBytes)
-	logger.Info("Track Header:", string(trackHeaderBytes))
-
-	// track length is the next 4 bytes (32 bits) in big endian
-	trackLengthBytes := make([]byte, 4)
-	_, err = dat.Read(trackLengthBytes)
-	trackLengthInt := binary.BigEndian.Uint32(trackLengthBytes)
-	logger.Info("Track Length:", trackLengthInt)
-
-	// read track data in the format:
-	// <MTrk event> = <delta-time><event>
-	// <delta-time> is stored as a variable-length quantity.
-	// It represents the amount of time before the following event.
-	// 	If the first event in a track occurs at the very beginning of a track, or if two events occur simultaneously, a delta-time of zero is used. Delta-times are always present.
-	// (Not storing delta-times of 0 requires at least two bytes for any other value, and most delta-times aren't zero.)
-	// Delta-time is in some fraction of a beat (or a second, for recording a track with SMPTE times), as specified in the header chunk.
-	// <event> = <MIDI event> | <sysex event> | <meta-event>
-	// Print only note on and note offf midi events and their data as well as delta time events
-	// eventsRemaining := 6
-	done := false
-	for !done {
-		// eventsRemaining--
-		logger.Debug("------- EVENT -------")
-		deltaTime := readVariableLengthValue2(dat)
-		logger.Debug("Delta Time:", deltaTime)
-
-		// <event> = <MIDI event> | <sysex event> | <meta-event>
-		eventFirstByte := make([]byte, 1)
-		_, err = dat.Read(eventFirstByte)
-		check(err)
-		logger.Debug("Event first byte: %x\n", eventFirstByte[0])
-
-		if eventFirstByte[0] == 0xFF {
-			// <meta-event> = 0xFF<type><length><data>
-			metaEventType := make([]byte, 1)
-			_, err = dat.Read(metaEventType)
-			check(err)
-
-			metaEventLength := readVariableLengthValue2(dat)
-
-			switch metaEventType[0] {
-			case 0x03:
-				{
-					trackName := make([]byte, metaEventLength)
-					_, err = dat.Read(trackName)
-					check(err)
-					logger.Debug("Meta Event Type: %s (Track Name)\n", trackName)
-					logger.Debug("  Track Name:", string(trackName))
-
-					break
-				}
-			case 0x2F:
-				{
-					logger.Debug("Meta Event Type: %x (End of Track)\n", metaEventType[0])
-					if metaEventLength != 0 {
-						panic("Invalid End of Track Length")
-					}
-					// consume the data even though we don't use it now
-					// metaEventData := make([]byte, metaEventLength)
-					// _, err = dat.Read(metaEventData)
-					// check(err)
-					done = true
-					break
-				}
-			case 0x58:
-				{
-					logger.Debug("Meta Event Type: %x (Time Signature)\n", metaEventType[0])
-					if metaEventLength != 4 {
-						panic("Invalid Time Signature Length")
-					}
-
-					numerator := make([]byte, 1)
-					_, err = dat.Read(numerator)
-					check(err)
-					denominator := make([]byte, 1)
-					_, err = dat.Read(denominator)
-					check(err)
-					cc := make([]byte, 1)
-					_, err = dat.Read(cc)
-					check(err)
-					bb := make([]byte, 1)
-					_, err = dat.Read(bb)
-					check(err)
-					logger.Debug("  Numerator:", numerator[0])
-					logger.Debug("  Denominator:", denominator[0])
-					break
-				}
-			case 0x51:
-				{
-					logger.Debug("Meta Event Type: %x (Set Tempo)\n", metaEventType[0])
-					if metaEventLength != 3 {
-						panic("Invalid Set Tempo Length")
-					}
-
-					mpqn := make([]byte, 3)
-					_, err = dat.Read(mpqn)
-					check(err)
-					microSecondsPerQuarterNoteInt := uint32(mpqn[0])<<16 | uint32(mpqn[1])<<8 | uint32(mpqn[2])
-					logger.Info("  Microseconds Per Quarter Note:", microSecondsPerQuarterNoteInt)
-					break
-				}
-			default:
-				logger.Debug("Meta Event Type: %x\n", metaEventType[0])
-				logger.Debug("Meta Event Length:", metaEventLength)
-
-				// consume the data even though we don't use it now
-				metaEventData := make([]byte, metaEventLength)
-				_, err = dat.Read(metaEventData)
-				check(err)
-			}
-
-			// logger.Debug("Meta Event Data:", string(metaEventData))
-		} else if eventFirstByte[0] == 0xF0 || eventFirstByte[0] == 0xF7 {
-			// <sysex event> = 0xF0<length><data> or 0xF7<length><data>
-			sysexEventLength := readVariableLengthValue2(dat)
-			logger.Debug("Sysex Event Length:", sysexEventLength)
-			// consume the data even though we don't use it now
-			sysexEventData := make([]byte, sysexEventLength)
-			_, err = dat.Read(sysexEventData)
-			check(err)
-		} else {
-			// <MIDI event> = <MIDI event type><channel><data>
-			// <MIDI event type> = <MIDI event type (4 bits)><MIDI channel (4 bits)>
-			// <MIDI event type> = 0x8 for note off, 0x9 for note on
-			midiEventType := eventFirstByte[0]
-			logger.Debug("RAW MIDI Event Type: %x\n", midiEventType)
-
-			// midiChannel := midiEventType & 0x0F
-			midiEventType = midiEventType >> 4
-
-			switch midiEventType {
-			case 0x8:
-				{
-					logger.Debug("MIDI Event Type: Note Off")
-					note := make([]byte, 1)
-					_, err = dat.Read(note)
-					check(err)
-					velocity := make([]byte, 1)
-					_, err = dat.Read(velocity)
-					check(err)
-					logger.Debug("  Note:", note[0], noteNumberToString(note[0]))
-					logger.Debug("  Velocity:", velocity[0])
-
-					midiTrack.notes = append(midiTrack.notes, MidiNote{
-						deltaTime: deltaTime,
-						eventType: NoteOff,
-						channel:   0,
-						note:      note[0],
-						velocity:  velocity[0],
-					})
-					break
-				}
-			case 0x9:
-				{
-					logger.Debug("MIDI Event Type: Note On")
-					note := make([]byte, 1)
-					_, err = dat.Read(note)
-					check(err)
-					velocity := make([]byte, 1)
-					_, err = dat.Read(velocity)
-					check(err)
-					logger.Debug("  Note:", note[0], noteNumberToString(note[0]))
-					logger.Debug("  Velocity:", velocity[0])
-
-					midiTrack.notes = append(midiTrack.notes, MidiNote{
-						deltaTime: deltaTime,
-						eventType: NoteOn,
-						channel:   0,
-						note:      note[0],
-						velocity:  velocity[0],
-					})
-					break
-				}
-			}
+	if *check {
+		failed, err := midivis.WriteReports(os.Stdout, vis.Check(filePaths))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if failed {
+			os.Exit(1)
 		}
+		return
 	}
 
-	return midiTrack
-}
-
-func (midiTrack *MidiTrack) ToTrack(logger *slog.Logger, fileName string) *Track {
-	track := NewTrack(fileName, midiTrack.ppqn)
-	deltaTotal := 0
-	noteOnMap := make(map[byte]Note)
-	for _, midiNote := range midiTrack.notes {
-		deltaTotal += midiNote.deltaTime
-
-		if midiNote.eventType == NoteOn {
-			noteOnMap[midiNote.note] = Note{
-				on:  deltaTotal,
-				off: -1,
-				num: int(midiNote.note),
-				str: noteNumberToString(midiNote.note),
-				vel: int(midiNote.velocity),
-			}
-		} else if midiNote.eventType == NoteOff {
-			if foundNote, ok := noteOnMap[midiNote.note]; ok {
-				foundNote.off = deltaTotal
-				track.notes = append(track.notes, foundNote)
-				delete(noteOnMap, midiNote.note)
-			} else {
-				logger.Info("Note Off without Note On")
-			}
+	// skip files that fail to parse so one bad file doesn't stop the rest from rendering
+	for i, err := range vis.LoadFiles(filePaths) {
+		if err != nil {
+			opts.Logger.Warn("Skipping midi file", "fileName", filePaths[i], "err", err)
 		}
 	}
 
-	return track
-}
-
-func secondsToDeltaTime(elapsedTime float64, microSecondsPerQuarterNote int, ppqn int) int {
-	// Convert microseconds per quarter note to seconds per tick
-	secondsPerTick := float64(microSecondsPerQuarterNote) / (1000000.0 * float64(ppqn))
-
-	// Calculate delta time in ticks
-	deltaTime := elapsedTime / secondsPerTick
-
-	// Round to the nearest integer (since delta time must be an integer value in MIDI)
-	return int(math.Round(deltaTime))
-}
-
-func deltaTimeToSeconds(deltaTime int, microSecondsPerQuarterNote int, ppqn int) float64 {
-	// Convert microseconds per quarter note to seconds per tick
-	secondsPerTick := float64(microSecondsPerQuarterNote) / (1000000.0 * float64(ppqn))
-
-	// Calculate elapsed time in seconds
-	elapsedTime := float64(deltaTime) * secondsPerTick
-
-	return elapsedTime
-}
-
-// startRender starts the rendering loop
-func startRender(tracks []*Track, logger *slog.Logger) {
-	// Use noteTopBottomPaddingPixels to adjust the padding at the top and bottom of screen for notes
-	const noteTopBottomPaddingPixels = 50
-
-	// Use Normalize and/or noteMin/noteMax to adjust the range of notes displayed
-	const normalize = true
-	noteMin := 0
-	noteMax := 127
-	if normalize {
-		allNotes := make([]Note, 0)
-		for _, t := range tracks {
-			allNotes = append(allNotes, t.notes...)
+	if *notesFileName != "" {
+		if err := vis.LoadCSVFile(*notesFileName); err != nil {
+			log.Fatal(err)
 		}
+	}
 
-		sort.Slice(allNotes, func(i, j int) bool {
-			return allNotes[i].num < allNotes[j].num
-		})
-
-		logger.Debug("Sorted Notes:")
-		for _, note := range allNotes {
-			logger.Debug("Note: %d %d %d %d\n", note.num, note.on, note.off, note.vel)
+	if *compareFileName != "" {
+		if err := vis.LoadCompareFile(*compareFileName); err != nil {
+			log.Fatal(err)
 		}
-
-		noteMin = allNotes[0].num
-		noteMax = allNotes[len(allNotes)-1].num
 	}
 
-	noteHeight := (height - noteTopBottomPaddingPixels*2) / (noteMax - noteMin)
-
-	// Use xTranslate to adjust the horizontal translation of the notes (e.g. where the note-on should be occur)
-	const xTranslate = width / 2
-
-	// Setup audio player
-	// TODO: hardcoded for now...
-	const sampleRate = 44100
-	audioContext := audio.NewContext(sampleRate)
-
-	audioFile, err := os.Open("A. G. Cook - Idyll.mp3")
-	check(err)
-	s, err := mp3.DecodeF32(audioFile)
-	if err != nil {
-		panic(err)
+	if *only != "" && len(filePaths) == 0 {
+		opts.Logger.Warn("-only didn't match any midi files", "only", *only)
 	}
 
-	p, err := audioContext.NewPlayerF32(s)
-	if err != nil {
-		panic(err)
+	if *list {
+		if err := vis.WriteList(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	ebiten.SetWindowSize(width, height)
-	ebiten.SetWindowTitle("Hello, World!")
-	notes := make([]Renderable, 0)
-	for trackIndex, t := range tracks {
-		typeToUse, ok := fileNameToType[t.name]
-		if !ok {
-			logger.Info("Using default note type", "trackName", t.name)
-			typeToUse = NoteTypeRect
-		}
-		colorsToUse := []color.RGBA{
-			colornames.Red,
-			colornames.Blue,
-			colornames.Green,
-			colornames.Yellow,
-			colornames.Purple,
-			colornames.White,
-		}
-		chosenColor := colorsToUse[trackIndex%len(colorsToUse)]
-		for noteIndex, note := range t.notes {
-			if typeToUse == NoteTypeScreen {
-				z := -10
-				notes = append(notes, &NoteScreen{
-					RenderableNoteBase: RenderableNoteBase{
-						Note: note,
-						z:    z,
-					},
-					color: &chosenColor,
-				})
-			} else if typeToUse == NoteTypeMeter {
-				z := -5
-				notes = append(notes, &NoteMeter{
-					RenderableNoteBase: RenderableNoteBase{
-						Note: note,
-						z:    z,
-					},
-					color: &chosenColor,
-				})
-			} else if typeToUse == NoteTypeZoom {
-				z := -1
-				notes = append(notes, &NoteZoom{
-					RenderableNoteBase: RenderableNoteBase{
-						Note: note,
-						z:    z,
-					},
-					color: &chosenColor,
-				})
-			} else if typeToUse == NoteTypeRadialGradient {
-				z := 0
-				notes = append(notes, &NoteRadialGradient{
-					RenderableNoteBase: RenderableNoteBase{
-						Note: note,
-						z:    z,
-					},
-					color: &chosenColor,
-				})
-			} else {
-				z := 0
-				xScale := 2.0
-				if noteIndex%2 == 0 {
-					xScale = 1
-				}
-				notes = append(notes, &NoteRect{
-					RenderableNoteBase: RenderableNoteBase{
-						Note: note,
-						z:    z,
-					},
-					color:  &chosenColor,
-					xScale: xScale,
-				})
-			}
+	if *csvFileName != "" {
+		f, err := os.Create(*csvFileName)
+		if err != nil {
+			log.Fatal(err)
 		}
+		defer f.Close()
 
-		// kind of dumb to sort here but let's do it anyways for now
-		sort.Slice(notes, func(i, j int) bool {
-			return notes[i].GetZ() < notes[j].GetZ()
-		})
-	}
-
-	shader, err := ebiten.NewShader(radialblur_kage)
-	if err != nil {
-		log.Fatal(err)
-	}
-	radialBlurShaderOpts := &ebiten.DrawRectShaderOptions{}
-	radialBlurShaderOpts.Uniforms = map[string]any{
-		"Time":   0,
-		"Cursor": []float32{float32(0), float32(0)},
-		"Center": []float32{float32(width / 2), float32(height / 2)},
+		if err := vis.WriteCSV(f); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	colormodShader, err := ebiten.NewShader(colormod_kage)
+	stopProfiling, err := startProfiling(*cpuProfileFileName, *traceFileName)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	radialGradientShader, err := ebiten.NewShader(radialgradient_kage)
+	// stop before exiting so the profiles are flushed even if Run failed
+	err = vis.Run()
+	stopProfiling()
 	if err != nil {
 		log.Fatal(err)
 	}
+}
 
-	radialGradientShaderOpts := &ebiten.DrawRectShaderOptions{}
-	radialGradientShaderOpts.Uniforms = map[string]interface{}{
-		"PctShow": 0,
-	}
-
-	p.Play()
-
-	game := &Game{
-		currentTick:      0,
-		elapsedDeltaTime: 0,
-		playerMeasure:    0,
-		// Assuming all tracks are the same ppqn...
-		ppqn:                       int(tracks[0].ppqn),
-		tracks:                     tracks,
-		notes:                      notes,
-		noteMin:                    noteMin,
-		noteHeight:                 noteHeight,
-		noteTopBottomPaddingPixels: noteTopBottomPaddingPixels,
-		xTranslate:                 xTranslate,
-
-		shader:               shader,
-		radialBlurShaderOpts: radialBlurShaderOpts,
-
-		colormodShader: colormodShader,
-
-		radialGradientShader:     radialGradientShader,
-		radialGradientShaderOpts: radialGradientShaderOpts,
-
-		player: p,
+// statFile returns the info of a file of fsys, or of the OS's file system when fsys is nil
+func statFile(fsys fs.FS, name string) (fs.FileInfo, error) {
+	if fsys == nil {
+		return os.Stat(name)
 	}
+	return fs.Stat(fsys, name)
+}
 
-	if err := ebiten.RunGame(game); err != nil {
-		log.Fatal(err)
+// readDir lists a directory of fsys, or of the OS's file system when fsys is nil
+func readDir(fsys fs.FS, name string) ([]fs.DirEntry, error) {
+	if fsys == nil {
+		return os.ReadDir(name)
 	}
+	return fs.ReadDir(fsys, name)
 }
 
-func main() {
-	loggerLevel := slog.LevelInfo
-	if debug {
-		loggerLevel = slog.LevelDebug
+// startProfiling starts the cpu profile and execution trace for the non empty file names,
+// the returned func stops them and closes their files
+func startProfiling(cpuProfileFileName, traceFileName string) (func(), error) {
+	stops := []func(){}
+	stop := func() {
+		for _, s := range stops {
+			s()
+		}
 	}
-	loggerOpts := &slog.HandlerOptions{Level: loggerLevel}
-	logger := slog.New(slog.NewTextHandler(os.Stdout, loggerOpts))
 
-	tracks := make([]*Track, 0)
-
-	files, err := os.ReadDir("./ag")
-	if err != nil {
-		panic(err)
+	if cpuProfileFileName != "" {
+		f, err := os.Create(cpuProfileFileName)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		})
 	}
 
-	for _, file := range files {
-		if file.IsDir() || !strings.HasSuffix(file.Name(), ".mid") {
-			continue
+	if traceFileName != "" {
+		f, err := os.Create(traceFileName)
+		if err != nil {
+			stop()
+			return nil, err
 		}
-
-		filePath := fmt.Sprintf("./ag/%s", file.Name())
-		midiTrack := parseMidiFile(logger, filePath)
-		tracks = append(tracks, midiTrack.ToTrack(logger, file.Name()))
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			stop()
+			return nil, err
+		}
+		stops = append(stops, func() {
+			trace.Stop()
+			f.Close()
+		})
 	}
 
-	startRender(tracks, logger)
+	return stop, nil
 }
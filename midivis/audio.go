@@ -0,0 +1,63 @@
+package midivis
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/hajimehoshi/ebiten/v2/audio/mp3"
+	"github.com/hajimehoshi/ebiten/v2/audio/vorbis"
+	"github.com/hajimehoshi/ebiten/v2/audio/wav"
+)
+
+// audioStream is a decoded stream of any of the supported audio formats
+type audioStream interface {
+	io.ReadSeeker
+	Length() int64
+	SampleRate() int
+}
+
+// errUnsupportedAudio is returned by decodeAudio for files of other formats
+var errUnsupportedAudio = errors.New("unsupported audio file, the extension must be .mp3, .wav or .ogg")
+
+// decodeAudio decodes an mp3, wav or ogg file picked by the file name's extension.
+// The samples are 32-bit floats, or 16-bit integers without resampling when s16 is set
+func decodeAudio(r io.Reader, fileName string, s16 bool) (audioStream, error) {
+	// the decoders return typed nil streams on errors, so each is checked before becoming an audioStream
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".mp3":
+		decode := mp3.DecodeF32
+		if s16 {
+			decode = mp3.DecodeWithoutResampling
+		}
+		s, err := decode(r)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case ".wav":
+		decode := wav.DecodeF32
+		if s16 {
+			decode = wav.DecodeWithoutResampling
+		}
+		s, err := decode(r)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case ".ogg":
+		decode := vorbis.DecodeF32
+		if s16 {
+			decode = vorbis.DecodeWithoutResampling
+		}
+		s, err := decode(r)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("%s: %w", fileName, errUnsupportedAudio)
+	}
+}
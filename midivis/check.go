@@ -0,0 +1,80 @@
+package midivis
+
+import (
+	"fmt"
+	"io"
+)
+
+// FileReport lists the problems found in a midi file by Check
+type FileReport struct {
+	FileName string
+	// Err is set when the file failed to parse
+	Err error
+	// Warnings are problems that don't stop the file from rendering, like notes without a note off
+	Warnings []string
+}
+
+// Check parses the midi files without adding them and reports the problems of each file.
+// Besides the parser's warnings, files without a tempo or with a different ppqn from the first file are reported
+func (v *Visualizer) Check(fileNames []string) []FileReport {
+	reports := make([]FileReport, len(fileNames))
+	firstPPQN := uint16(0)
+	for i, fileName := range fileNames {
+		reports[i].FileName = fileName
+
+		tracks, err := v.parseFile(v.opts.FS, fileName)
+		if err != nil {
+			reports[i].Err = err
+			continue
+		}
+		if len(tracks) == 0 {
+			continue
+		}
+
+		for _, track := range tracks {
+			reports[i].Warnings = append(reports[i].Warnings, track.warnings...)
+		}
+		// the tracks of a file share its tempo map and ppqn
+		track := tracks[0]
+		if len(track.tempoChanges) == 0 {
+			reports[i].Warnings = append(reports[i].Warnings, fmt.Sprintf("Missing tempo, assuming %d us per quarter note", defaultMicroSecondsPerQuarterNote))
+		}
+
+		if firstPPQN == 0 {
+			firstPPQN = track.ppqn
+		} else if track.ppqn != firstPPQN {
+			reports[i].Warnings = append(reports[i].Warnings, fmt.Sprintf("PPQN %d differs from the first file's %d", track.ppqn, firstPPQN))
+		}
+	}
+
+	return reports
+}
+
+// WriteReports writes the reports, one line per problem under each file's name. It returns whether any file failed to parse
+func WriteReports(w io.Writer, reports []FileReport) (failed bool, err error) {
+	for _, report := range reports {
+		status := "ok"
+		if report.Err != nil {
+			status = "failed"
+			failed = true
+		} else if len(report.Warnings) > 0 {
+			status = fmt.Sprintf("%d warnings", len(report.Warnings))
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", report.FileName, status); err != nil {
+			return failed, err
+		}
+
+		if report.Err != nil {
+			if _, err := fmt.Fprintf(w, "  error: %v\n", report.Err); err != nil {
+				return failed, err
+			}
+		}
+		for _, warning := range report.Warnings {
+			if _, err := fmt.Fprintf(w, "  warning: %s\n", warning); err != nil {
+				return failed, err
+			}
+		}
+	}
+
+	return failed, nil
+}
@@ -0,0 +1,307 @@
+package midivis
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"golang.org/x/image/colornames"
+)
+
+// Map note type names used in config files to note types
+var noteTypeNames = map[string]int{
+	"rect":           NoteTypeRect,
+	"screen":         NoteTypeScreen,
+	"meter":          NoteTypeMeter,
+	"zoom":           NoteTypeZoom,
+	"radialgradient": NoteTypeRadialGradient,
+	"contour":        NoteTypeContour,
+	"chord":          NoteTypeChord,
+}
+
+// RenderConfig describes how each midi file is rendered
+type RenderConfig struct {
+	// Normalize fits the displayed note range to the notes of the files instead of all 128 midi notes
+	Normalize bool `json:"normalize"`
+	// Files holds per file settings, the first entry whose pattern matches a file is used
+	Files []*FileConfig `json:"files"`
+	// Easing maps note type names to the easing of their animations, e.g. {"zoom": "ease-out"}
+	Easing map[string]string `json:"easing,omitempty"`
+	// LeadIn maps note type names to the number of beats their animations start before the note on, e.g. {"zoom": 4}
+	LeadIn map[string]float64 `json:"leadIn,omitempty"`
+	// PitchColors maps pitch classes to colors overriding the track colors, e.g. {"C": "red", "F#": "#00ff00"}
+	PitchColors map[string]string `json:"pitchColors,omitempty"`
+
+	// easing maps note types to easing kinds, resolved from Easing
+	easing map[int]string
+	// leadIn maps note types to lead-ins in beats, resolved from LeadIn
+	leadIn map[int]float64
+	// pitchColors maps pitch classes from 0 for C to 11 for B to colors, resolved from PitchColors
+	pitchColors map[int]color.RGBA
+}
+
+// FileConfig holds the render settings for the files matching Pattern.
+// Every setting is optional, unset settings use the same defaults as when there's no config.
+type FileConfig struct {
+	// Pattern is matched against the midi file name, e.g. "kick.mid" or "*vocal*.mid"
+	Pattern string `json:"pattern"`
+	// Type is the name of the note type used to render the notes, e.g. "zoom"
+	Type string `json:"type,omitempty"`
+	// Color is a color name (e.g. "red") or hex color (e.g. "#ff0000")
+	Color string `json:"color,omitempty"`
+	// Z is the z-index of the notes, overriding the note type's default
+	Z *int `json:"z,omitempty"`
+	// Channel only renders notes on this channel when set
+	Channel *int `json:"channel,omitempty"`
+	// Normalize includes the file's notes when computing the displayed note range, defaults to true
+	Normalize *bool `json:"normalize,omitempty"`
+	// VelocityHeight scales the height of meter notes by their velocity, so soft hits are thin and hard hits tall
+	VelocityHeight bool `json:"velocityHeight,omitempty"`
+	// ZoomAnimate is which dimensions of zoom notes grow before the note on, "width", "height" or "both", defaults to "both"
+	ZoomAnimate string `json:"zoomAnimate,omitempty"`
+	// ChannelTypes maps channels to the names of their note types overriding Type, e.g. {"9": "screen"}.
+	// Useful for format 0 files, where all of the parts share one track but differ by channel
+	ChannelTypes map[int]string `json:"channelTypes,omitempty"`
+
+	noteType int
+	color    *color.RGBA
+	// channelNoteTypes maps channels to note types, resolved from ChannelTypes
+	channelNoteTypes map[int]int
+}
+
+func NewRenderConfig() *RenderConfig {
+
+	return &RenderConfig{
+		Normalize:   true,
+		Files:       []*FileConfig{},
+		easing:      map[int]string{},
+		leadIn:      map[int]float64{},
+		pitchColors: map[int]color.RGBA{},
+	}
+}
+
+// LoadRenderConfig reads a RenderConfig from a json file, validating its settings
+func LoadRenderConfig(fileName string) (*RenderConfig, error) {
+	dat, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	config := NewRenderConfig()
+	if err := json.Unmarshal(dat, config); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", fileName, err)
+	}
+
+	for i, fileConfig := range config.Files {
+		if err := fileConfig.validate(); err != nil {
+			return nil, fmt.Errorf("config %s, files[%d]: %w", fileName, i, err)
+		}
+	}
+
+	for typeName, kind := range config.Easing {
+		noteType, err := parseNoteType(typeName)
+		if err != nil {
+			return nil, fmt.Errorf("config %s, easing: %w", fileName, err)
+		}
+		if err := validateEasing(kind); err != nil {
+			return nil, fmt.Errorf("config %s, easing of %s: %w", fileName, typeName, err)
+		}
+		config.easing[noteType] = kind
+	}
+
+	for typeName, beats := range config.LeadIn {
+		noteType, err := parseNoteType(typeName)
+		if err != nil {
+			return nil, fmt.Errorf("config %s, leadIn: %w", fileName, err)
+		}
+		if beats <= 0 {
+			return nil, fmt.Errorf("config %s, leadIn of %s: must be positive, got %v", fileName, typeName, beats)
+		}
+		config.leadIn[noteType] = beats
+	}
+
+	for pitchClass, colorName := range config.PitchColors {
+		pc := slices.Index(noteNames, strings.ToUpper(pitchClass))
+		if pc == -1 {
+			return nil, fmt.Errorf("config %s, pitchColors: invalid pitch class %q, valid pitch classes are: %s", fileName, pitchClass, strings.Join(noteNames, ", "))
+		}
+		clr, err := parseColor(colorName)
+		if err != nil {
+			return nil, fmt.Errorf("config %s, pitchColors of %s: %w", fileName, pitchClass, err)
+		}
+		config.pitchColors[pc] = clr
+	}
+
+	return config, nil
+}
+
+// easingFor returns the easing kind of the note type's animations
+func (config *RenderConfig) easingFor(noteType int) string {
+	if kind, ok := config.easing[noteType]; ok {
+		return kind
+	}
+
+	return defaultEasing
+}
+
+// pitchColor returns the color overriding the track color of notes of the note number's pitch class, if any
+func (config *RenderConfig) pitchColor(num int) (color.RGBA, bool) {
+	clr, ok := config.pitchColors[num%12]
+	return clr, ok
+}
+
+// Default lead-in in beats of the note types with an animation before the note on
+var defaultLeadIn = map[int]float64{
+	NoteTypeMeter: 1,
+	NoteTypeZoom:  2,
+}
+
+// leadInTicks returns the number of ticks the note type's animations start before the note on
+func (config *RenderConfig) leadInTicks(noteType int, ppqn int) int {
+	beats, ok := config.leadIn[noteType]
+	if !ok {
+		beats = defaultLeadIn[noteType]
+	}
+
+	// at least a tick, the animations divide by their lead-in
+	return max(int(beats*float64(ppqn)), 1)
+}
+
+// validate checks the settings and resolves the note type and color
+func (c *FileConfig) validate() error {
+	if c.Pattern == "" {
+		return fmt.Errorf("missing pattern")
+	}
+	if _, err := path.Match(c.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", c.Pattern, err)
+	}
+
+	if c.Type != "" {
+		noteType, err := parseNoteType(c.Type)
+		if err != nil {
+			return err
+		}
+		c.noteType = noteType
+	}
+
+	if c.Color != "" {
+		clr, err := parseColor(c.Color)
+		if err != nil {
+			return err
+		}
+		c.color = &clr
+	}
+
+	if c.Channel != nil && (*c.Channel < 0 || *c.Channel > 15) {
+		return fmt.Errorf("invalid channel %d, must be between 0 and 15", *c.Channel)
+	}
+
+	switch c.ZoomAnimate {
+	case "", "both", "width", "height":
+	default:
+		return fmt.Errorf("invalid zoomAnimate %q, valid values are: both, width, height", c.ZoomAnimate)
+	}
+
+	c.channelNoteTypes = map[int]int{}
+	for channel, typeName := range c.ChannelTypes {
+		if channel < 0 || channel > 15 {
+			return fmt.Errorf("invalid channelTypes channel %d, must be between 0 and 15", channel)
+		}
+		noteType, err := parseNoteType(typeName)
+		if err != nil {
+			return fmt.Errorf("channelTypes of %d: %w", channel, err)
+		}
+		c.channelNoteTypes[channel] = noteType
+	}
+
+	return nil
+}
+
+// forFile returns the settings of the first entry matching the file name, or nil if none match
+func (config *RenderConfig) forFile(fileName string) *FileConfig {
+	for _, fileConfig := range config.Files {
+		if ok, _ := path.Match(fileConfig.Pattern, fileName); ok {
+			return fileConfig
+		}
+	}
+
+	return nil
+}
+
+// includeInNormalize reports whether the file's notes count towards the displayed note range
+func (c *FileConfig) includeInNormalize() bool {
+	return c == nil || c.Normalize == nil || *c.Normalize
+}
+
+// velocityHeight reports whether the height of meter notes scales with their velocity
+func (c *FileConfig) velocityHeight() bool {
+	return c != nil && c.VelocityHeight
+}
+
+// zoomAnimates reports which dimensions of zoom notes grow before the note on, the others are drawn at full size
+func (c *FileConfig) zoomAnimates() (width, height bool) {
+	if c == nil {
+		return true, true
+	}
+
+	switch c.ZoomAnimate {
+	case "width":
+		return true, false
+	case "height":
+		return false, true
+	default:
+		return true, true
+	}
+}
+
+// channelNoteType returns the note type of the channel's notes, if the channel has its own
+func (c *FileConfig) channelNoteType(channel int) (int, bool) {
+	if c == nil {
+		return 0, false
+	}
+	noteType, ok := c.channelNoteTypes[channel]
+	return noteType, ok
+}
+
+// includesChannel reports whether notes on the channel should be rendered
+func (c *FileConfig) includesChannel(channel int) bool {
+	return c == nil || c.Channel == nil || *c.Channel == channel
+}
+
+func parseNoteType(name string) (int, error) {
+	noteType, ok := noteTypeNames[strings.ToLower(name)]
+	if !ok {
+		validNames := make([]string, 0, len(noteTypeNames))
+		for validName := range noteTypeNames {
+			validNames = append(validNames, validName)
+		}
+		sort.Strings(validNames)
+
+		return 0, fmt.Errorf("unknown note type %q, valid types are: %s", name, strings.Join(validNames, ", "))
+	}
+
+	return noteType, nil
+}
+
+// parseColor parses a color name from colornames or a hex color in the form #rrggbb
+func parseColor(s string) (color.RGBA, error) {
+	if strings.HasPrefix(s, "#") {
+		var r, g, b uint8
+		if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &r, &g, &b); err != nil || len(s) != 7 {
+			return color.RGBA{}, fmt.Errorf("invalid hex color %q, expected #rrggbb", s)
+		}
+		return color.RGBA{R: r, G: g, B: b, A: 0xff}, nil
+	}
+
+	clr, ok := colornames.Map[strings.ToLower(s)]
+	if !ok {
+		return color.RGBA{}, fmt.Errorf("unknown color %q", s)
+	}
+
+	return clr, nil
+}
@@ -0,0 +1,150 @@
+package midivis
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+)
+
+// csvPPQN is the ppqn of tracks loaded from csv files, their ticks are in quarter notes of this many ticks
+const csvPPQN = 480
+
+// WriteCSV writes one row per note of every track, with timing in ticks and seconds
+func (v *Visualizer) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := []string{
+		"track", "channel", "note", "name",
+		"on_tick", "off_tick", "on_seconds", "off_seconds", "duration_seconds",
+		"velocity", "measure",
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, t := range v.tracks {
+		ppqn := int(t.ppqn)
+		for _, n := range t.notes {
+			onSeconds := t.tickToSeconds(n.on)
+			offSeconds := t.tickToSeconds(n.off)
+			row := []string{
+				t.name,
+				strconv.Itoa(n.channel),
+				strconv.Itoa(n.num),
+				n.str,
+				strconv.Itoa(n.on),
+				strconv.Itoa(n.off),
+				strconv.FormatFloat(onSeconds, 'f', 6, 64),
+				strconv.FormatFloat(offSeconds, 'f', 6, 64),
+				strconv.FormatFloat(offSeconds-onSeconds, 'f', 6, 64),
+				strconv.Itoa(n.vel),
+				strconv.Itoa(n.on / (ppqn * 4)),
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// LoadCSVFile loads a track from a csv file of notes, named after the file. See parseCSVTrack for the format.
+// The game times every track by the first track's ppqn, so the ticks are rescaled to the ppqn of the tracks already added
+func (v *Visualizer) LoadCSVFile(fileName string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	track, err := parseCSVTrack(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+	track.name = path.Base(fileName)
+	if len(v.tracks) > 0 {
+		rescaleTicks(track, v.tracks[0].ppqn)
+	}
+	v.AddTrack(track)
+
+	return nil
+}
+
+// parseCSVTrack reads a track from csv rows of note,on_tick,off_tick,velocity,channel, with csvPPQN ticks per quarter note.
+// Notes are midi note numbers or names like C5, a header row is skipped
+func parseCSVTrack(r io.Reader) (*Track, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 5
+	cr.TrimLeadingSpace = true
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	// firstRow is the line number of the first note row, for errors
+	firstRow := 1
+	if len(rows) > 0 && rows[0][0] == "note" {
+		rows = rows[1:]
+		firstRow = 2
+	}
+
+	track := NewTrack("", csvPPQN)
+	for i, row := range rows {
+		rowNumber := i + firstRow
+		num, err := ParseNote(row[0])
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", rowNumber, err)
+		}
+
+		values := make([]int, 4)
+		for j, field := range row[1:] {
+			if values[j], err = strconv.Atoi(field); err != nil {
+				return nil, fmt.Errorf("row %d: invalid number %q", rowNumber, field)
+			}
+		}
+		on, off, vel, channel := values[0], values[1], values[2], values[3]
+
+		if on < 0 || off < on {
+			return nil, fmt.Errorf("row %d: off tick %d must be after on tick %d", rowNumber, off, on)
+		}
+		if vel < 0 || vel > 127 {
+			return nil, fmt.Errorf("row %d: invalid velocity %d, must be between 0 and 127", rowNumber, vel)
+		}
+		if channel < 0 || channel > 15 {
+			return nil, fmt.Errorf("row %d: invalid channel %d, must be between 0 and 15", rowNumber, channel)
+		}
+
+		str := noteNumberToString(byte(num))
+		if channel == percussionChannel {
+			str = noteNumberToPercussion(byte(num))
+		}
+		track.notes = append(track.notes, Note{
+			on:      on,
+			off:     off,
+			num:     num,
+			str:     str,
+			vel:     vel,
+			channel: channel,
+			pan:     centerPan,
+			program: -1,
+		})
+	}
+
+	return track, nil
+}
+
+// rescaleTicks converts the ticks of the track's notes to ppqn ticks per quarter note, keeping their timing in beats
+func rescaleTicks(track *Track, ppqn uint16) {
+	if track.ppqn == ppqn {
+		return
+	}
+	for i := range track.notes {
+		track.notes[i].on = track.notes[i].on * int(ppqn) / int(track.ppqn)
+		track.notes[i].off = track.notes[i].off * int(ppqn) / int(track.ppqn)
+	}
+	track.ppqn = ppqn
+}
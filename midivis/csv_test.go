@@ -0,0 +1,41 @@
+package midivis
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCSVTrackRowNumbers(t *testing.T) {
+	tests := []struct {
+		name string
+		csv  string
+		want string
+	}{
+		{"header", "note,on_tick,off_tick,velocity,channel\nC5,0,480,100,0\nC5,0,480,200,0\n", "row 3:"},
+		{"no header", "C5,0,480,100,0\nC5,0,480,200,0\n", "row 2:"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseCSVTrack(strings.NewReader(test.csv))
+			if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+				t.Fatalf("got error %v, want it to start with %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestRescaleTicks(t *testing.T) {
+	track, err := parseCSVTrack(strings.NewReader("C5,480,1200,100,0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rescaleTicks(track, 96)
+	if track.ppqn != 96 {
+		t.Errorf("ppqn %d, want 96", track.ppqn)
+	}
+	// a beat to two and a half beats
+	if note := track.notes[0]; note.on != 96 || note.off != 240 {
+		t.Errorf("note is %d-%d, want 96-240", note.on, note.off)
+	}
+}
@@ -0,0 +1,70 @@
+package midivis
+
+import "sort"
+
+// noteIndex finds the renderables near a range of ticks by binary search, so drawing doesn't go through every note
+type noteIndex struct {
+	// byOn holds the renderables sorted by their first note on
+	byOn []indexedRenderable
+	// maxSpan is the most ticks any renderable spans, a renderable reaching into a range can't start before it by more
+	maxSpan int
+}
+
+type indexedRenderable struct {
+	r       Renderable
+	on, off int
+	// order is the position of the renderable in the draw order, sorted by z
+	order int
+}
+
+// newNoteIndex indexes the renderables in their draw order, it has to be rebuilt when they change
+func newNoteIndex(renderables []Renderable) *noteIndex {
+	idx := &noteIndex{byOn: make([]indexedRenderable, len(renderables))}
+	for i, r := range renderables {
+		on, off := drawSpan(r)
+		idx.byOn[i] = indexedRenderable{r: r, on: on, off: off, order: i}
+		idx.maxSpan = max(idx.maxSpan, off-on)
+	}
+	sort.SliceStable(idx.byOn, func(i, j int) bool {
+		return idx.byOn[i].on < idx.byOn[j].on
+	})
+
+	return idx
+}
+
+// drawSpan returns the ticks a renderable draws across, from the first note on to the last note off.
+// Contours draw a line to their next note, so they span up to it
+func drawSpan(r Renderable) (on, off int) {
+	notes := renderedNotes(r)
+	on, off = notes[0].on, notes[0].off
+	for _, n := range notes[1:] {
+		on, off = min(on, n.on), max(off, n.off)
+	}
+	if contour, ok := r.(*NoteContour); ok && contour.next != nil {
+		off = max(off, contour.next.on)
+	}
+	return on, off
+}
+
+// overlapping appends the renderables spanning any of the ticks from start to end to dst, keeping the draw order.
+// dst is reused between calls to avoid allocating every frame
+func (idx *noteIndex) overlapping(start, end int, dst []indexedRenderable) []indexedRenderable {
+	first := sort.Search(len(idx.byOn), func(i int) bool {
+		return idx.byOn[i].on >= start-idx.maxSpan
+	})
+
+	for _, indexed := range idx.byOn[first:] {
+		if indexed.on > end {
+			break
+		}
+		if indexed.off >= start {
+			dst = append(dst, indexed)
+		}
+	}
+
+	// the window is in note on order, draw it back in z order
+	sort.Slice(dst, func(i, j int) bool {
+		return dst[i].order < dst[j].order
+	})
+	return dst
+}
@@ -0,0 +1,142 @@
+package midivis
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+)
+
+// whiteSubImage is the source image of the rounded rect triangles, colored by their vertices
+var whiteSubImage = func() *ebiten.Image {
+	whiteImage := ebiten.NewImage(3, 3)
+	whiteImage.Fill(color.White)
+	return whiteImage.SubImage(image.Rect(1, 1, 2, 2)).(*ebiten.Image)
+}()
+
+// antialias reports whether shapes are drawn antialiased at the current quality
+func (g *Game) antialias() bool {
+	return g.quality < QualityNoAntialias
+}
+
+// echoSpacingBeats is how far apart in beats the echoes of a note are, the note scrolled this far between each echo
+const echoSpacingBeats = 1.0 / 16
+
+// drawEchoes draws Echoes fading copies of a scrolling note where it was in the recent past, oldest and faintest first.
+// xScale is the note's pixels per tick
+func (g *Game) drawEchoes(screen *ebiten.Image, x, y, w, h, xScale float32, clr *color.RGBA) {
+	spacing := float32(echoSpacingBeats*float64(g.ppqn)) * xScale
+	for i := g.opts.Echoes; i > 0; i-- {
+		alpha := math.Pow(g.opts.EchoDecay, float64(i))
+		// notes scroll left, so they were further right in the past
+		g.fillRect(screen, x+float32(i)*spacing, y, w, h, dimColor(*clr, alpha))
+	}
+}
+
+// mirrored calls draw with the rect, and again with the rect reflected across the horizontal midline when Mirror is set.
+// Rects smaller than MinNoteSize are grown to it so short notes don't flicker, only growing right and down
+// so the note ons stay in place
+func (g *Game) mirrored(x, y, w, h float32, draw func(x, y, w, h float32)) {
+	minSize := float32(g.opts.MinNoteSize)
+	w, h = max(w, minSize), max(h, minSize)
+
+	draw(x, y, w, h)
+	if g.opts.Mirror {
+		draw(x, g.mirrorY(y, h), w, h)
+	}
+}
+
+// mirrorY returns the top of a rect of height h at y reflected across the horizontal midline, a point for h of 0
+func (g *Game) mirrorY(y, h float32) float32 {
+	return float32(g.height) - h - y
+}
+
+// strokeLine draws a line between two points given in logical coordinates, and again reflected like mirrored when Mirror is set.
+// Lines thinner than MinNoteSize are thickened to it
+func (g *Game) strokeLine(dst *ebiten.Image, x0, y0, x1, y1, strokeWidth float32, clr color.Color) {
+	strokeWidth = max(strokeWidth, float32(g.opts.MinNoteSize))
+
+	vector.StrokeLine(dst, x0*g.scale, y0*g.scale, x1*g.scale, y1*g.scale, strokeWidth*g.scale, clr, g.antialias())
+	if g.opts.Mirror {
+		my0, my1 := g.mirrorY(y0, 0), g.mirrorY(y1, 0)
+		vector.StrokeLine(dst, x0*g.scale, my0*g.scale, x1*g.scale, my1*g.scale, strokeWidth*g.scale, clr, g.antialias())
+	}
+}
+
+// fillRect draws a filled note rect given in logical coordinates, with rounded corners when CornerRadius is set
+func (g *Game) fillRect(dst *ebiten.Image, x, y, w, h float32, clr color.Color) {
+	g.mirrored(x, y, w, h, func(x, y, w, h float32) {
+		if g.opts.CornerRadius <= 0 {
+			vector.DrawFilledRect(dst, x*g.scale, y*g.scale, w*g.scale, h*g.scale, clr, g.antialias())
+			return
+		}
+
+		path := roundedRectPath(x*g.scale, y*g.scale, w*g.scale, h*g.scale, float32(g.opts.CornerRadius)*g.scale)
+		vs, is := path.AppendVerticesAndIndicesForFilling(nil, nil)
+		g.drawVertices(dst, vs, is, clr)
+	})
+}
+
+// strokeRect draws a note rect outline given in logical coordinates, with rounded corners when CornerRadius is set
+func (g *Game) strokeRect(dst *ebiten.Image, x, y, w, h, strokeWidth float32, clr color.Color) {
+	g.mirrored(x, y, w, h, func(x, y, w, h float32) {
+		if g.opts.CornerRadius <= 0 {
+			vector.StrokeRect(dst, x*g.scale, y*g.scale, w*g.scale, h*g.scale, strokeWidth*g.scale, clr, g.antialias())
+			return
+		}
+
+		path := roundedRectPath(x*g.scale, y*g.scale, w*g.scale, h*g.scale, float32(g.opts.CornerRadius)*g.scale)
+		strokeOp := &vector.StrokeOptions{}
+		strokeOp.Width = strokeWidth * g.scale
+		vs, is := path.AppendVerticesAndIndicesForStroke(nil, nil, strokeOp)
+		g.drawVertices(dst, vs, is, clr)
+	})
+}
+
+// fillScreen covers the whole image with a color, blending with what's already drawn
+func (g *Game) fillScreen(dst *ebiten.Image, clr color.Color) {
+	w, h := g.scaledSize()
+	vector.DrawFilledRect(dst, 0, 0, float32(w), float32(h), clr, false)
+}
+
+// roundedRectPath returns the path of a rect with rounded corners, the radius is clamped to fit the rect
+func roundedRectPath(x, y, w, h, radius float32) *vector.Path {
+	// renderers can compute negative sizes while animating, normalize them so the corners are arcs
+	if w < 0 {
+		x, w = x+w, -w
+	}
+	if h < 0 {
+		y, h = y+h, -h
+	}
+	radius = min(radius, w/2, h/2)
+
+	path := &vector.Path{}
+	path.MoveTo(x+radius, y)
+	path.ArcTo(x+w, y, x+w, y+h, radius)
+	path.ArcTo(x+w, y+h, x, y+h, radius)
+	path.ArcTo(x, y+h, x, y, radius)
+	path.ArcTo(x, y, x+w, y, radius)
+	path.Close()
+
+	return path
+}
+
+// drawVertices draws the triangles of a path in a solid color
+func (g *Game) drawVertices(dst *ebiten.Image, vs []ebiten.Vertex, is []uint16, clr color.Color) {
+	r, gr, b, a := clr.RGBA()
+	for i := range vs {
+		vs[i].SrcX = 1
+		vs[i].SrcY = 1
+		vs[i].ColorR = float32(r) / 0xffff
+		vs[i].ColorG = float32(gr) / 0xffff
+		vs[i].ColorB = float32(b) / 0xffff
+		vs[i].ColorA = float32(a) / 0xffff
+	}
+
+	op := &ebiten.DrawTrianglesOptions{}
+	op.ColorScaleMode = ebiten.ColorScaleModePremultipliedAlpha
+	op.AntiAlias = g.antialias()
+	dst.DrawTriangles(vs, is, whiteSubImage, op)
+}
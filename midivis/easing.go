@@ -0,0 +1,71 @@
+package midivis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Easing kinds applied to the progress of the renderers' animations
+const (
+	EaseLinear    = "linear"
+	EaseIn        = "ease-in"
+	EaseOut       = "ease-out"
+	EaseInOut     = "ease-in-out"
+	EaseBounce    = "bounce"
+	defaultEasing = EaseLinear
+)
+
+var easingKinds = []string{EaseLinear, EaseIn, EaseOut, EaseInOut, EaseBounce}
+
+// ease maps linear progress t from 0 to 1 onto the easing curve of kind.
+// Linear and unknown kinds return t unchanged, other kinds clamp t to 0 to 1 first.
+func ease(t float64, kind string) float64 {
+	if kind == EaseLinear || kind == "" {
+		return t
+	}
+
+	t = min(max(t, 0), 1)
+	switch kind {
+	case EaseIn:
+		return t * t * t
+	case EaseOut:
+		return 1 - math.Pow(1-t, 3)
+	case EaseInOut:
+		if t < 0.5 {
+			return 4 * t * t * t
+		}
+		return 1 - math.Pow(-2*t+2, 3)/2
+	case EaseBounce:
+		// bounce out, settling at 1
+		const n1, d1 = 7.5625, 2.75
+		switch {
+		case t < 1/d1:
+			return n1 * t * t
+		case t < 2/d1:
+			t -= 1.5 / d1
+			return n1*t*t + 0.75
+		case t < 2.5/d1:
+			t -= 2.25 / d1
+			return n1*t*t + 0.9375
+		default:
+			t -= 2.625 / d1
+			return n1*t*t + 0.984375
+		}
+	default:
+		return t
+	}
+}
+
+func validateEasing(kind string) error {
+	for _, validKind := range easingKinds {
+		if kind == validKind {
+			return nil
+		}
+	}
+
+	validKinds := append([]string{}, easingKinds...)
+	sort.Strings(validKinds)
+	return fmt.Errorf("unknown easing %q, valid easings are: %s", kind, strings.Join(validKinds, ", "))
+}
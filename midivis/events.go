@@ -0,0 +1,72 @@
+package midivis
+
+import (
+	"sort"
+)
+
+// Kinds of note events passed to OnNoteEvent
+const (
+	NoteEventOn  = "on"
+	NoteEventOff = "off"
+)
+
+// noteEvent is a note turning on or off at a tick
+type noteEvent struct {
+	tick int
+	kind string
+	note Note
+}
+
+// newNoteEvents returns the note on and off events of the renderables' notes in tick order
+func newNoteEvents(renderables []Renderable) []noteEvent {
+	events := make([]noteEvent, 0, len(renderables)*2)
+	for _, r := range renderables {
+		for _, n := range renderedNotes(r) {
+			events = append(events, noteEvent{tick: n.on, kind: NoteEventOn, note: n})
+			events = append(events, noteEvent{tick: n.off, kind: NoteEventOff, note: n})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].tick < events[j].tick
+	})
+
+	return events
+}
+
+// updateNoteEvents calls OnNoteEvent and queues OSC messages for every note on and off crossed since the last update,
+// then sends the OSC messages together.
+// Seeks are jumps rather than crossings, they only send the events on the tick jumped to
+func (g *Game) updateNoteEvents() {
+	seeked := g.seeked
+	g.seeked = false
+	if g.opts.OnNoteEvent == nil && g.osc == nil {
+		return
+	}
+
+	from, to := g.lastElapsedDeltaTime, g.elapsedDeltaTime
+	if to < from || (to == from && !seeked) {
+		return
+	}
+
+	// the first event after from, or on it after a seek
+	i := sort.Search(len(g.noteEvents), func(i int) bool {
+		return g.noteEvents[i].tick > from || (seeked && g.noteEvents[i].tick == from)
+	})
+	for ; i < len(g.noteEvents) && g.noteEvents[i].tick <= to; i++ {
+		event := g.noteEvents[i]
+		if g.opts.OnNoteEvent != nil {
+			g.opts.OnNoteEvent(event.note, event.kind)
+		}
+		if g.osc != nil {
+			g.osc.queueNoteEvent(event.note, event.kind)
+		}
+	}
+
+	// the receiver may not be running yet, keep visualizing rather than stopping
+	if g.osc != nil {
+		if err := g.osc.flush(); err != nil {
+			g.opts.Logger.Warn("Failed to send OSC messages", "err", err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package midivis
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+// exportTick returns the tick of the current export frame.
+// With GridSnap the frames land exactly on GridSnap subdivisions of every beat, so loops cut on beats repeat seamlessly,
+// otherwise the frames are 1/FPS of a second apart.
+func (g *Game) exportTick() int {
+	if g.opts.GridSnap > 0 {
+		return g.exportFrame * g.ppqn / g.opts.GridSnap
+	}
+
+	return g.tempoMap.secondsToTick(float64(g.exportFrame) / float64(g.opts.FPS))
+}
+
+// updateExport moves on to the next frame once the current one is written, ending the game after the last note off.
+// Frames only advance after being drawn so none are skipped when rendering is slower than the TPS.
+func (g *Game) updateExport() error {
+	if g.exportErr != nil {
+		return g.exportErr
+	}
+
+	if g.exportFrameWritten {
+		g.exportFrame++
+		g.exportFrameWritten = false
+	}
+
+	g.elapsedDeltaTime = g.exportTick()
+	if g.elapsedDeltaTime > g.songEndTick {
+		return ebiten.Termination
+	}
+
+	return nil
+}
+
+// writeExportFrame writes the screen to a numbered png in ExportDir, errors are returned by the next updateExport
+func (g *Game) writeExportFrame(screen *ebiten.Image) {
+	if g.exportFrameWritten {
+		return
+	}
+
+	img := image.NewRGBA(screen.Bounds())
+	screen.ReadPixels(img.Pix)
+
+	f, err := os.Create(filepath.Join(g.opts.ExportDir, fmt.Sprintf("frame%06d.png", g.exportFrame)))
+	if err != nil {
+		g.exportErr = err
+		return
+	}
+	defer f.Close()
+
+	if err := png.Encode(f, img); err != nil {
+		g.exportErr = err
+		return
+	}
+
+	g.exportFrameWritten = true
+}
@@ -0,0 +1,1064 @@
+package midivis
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	_ "embed"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/audio"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+	"golang.org/x/image/colornames"
+)
+
+//go:embed shaders/radialblur.kage
+var radialblur_kage []byte
+
+//go:embed shaders/colormod.kage
+var colormod_kage []byte
+
+//go:embed shaders/radialgradient.kage
+var radialgradient_kage []byte
+
+// Default logical screen size, used when Options.Width and Options.Height aren't set
+const (
+	defaultWidth  = 1024
+	defaultHeight = 768
+)
+
+type Game struct {
+	// width and height are the logical screen size the renderers lay out in, set from the options
+	width  int
+	height int
+	// currentTick is the position of the tick clock in screen render ticks at normal speed
+	currentTick float64
+	// playbackRate scales the speed of the tick clock, between minPlaybackRate and maxPlaybackRate
+	playbackRate     float64
+	elapsedDeltaTime int
+	playerMeasure    int
+	ppqn             int
+	// tempoMap converts between ticks and the audio's time following the song's tempo changes
+	tempoMap                   TempoMap
+	tracks                     []*Track
+	notes                      []Renderable
+	noteMin                    int
+	noteHeight                 int
+	noteTopBottomPaddingPixels int
+	// lanes is the vertical lane of each track, nil unless Lanes is set
+	lanes      []noteLane
+	xTranslate float64
+	// songEndTick is the tick of the last note off
+	songEndTick int
+
+	shader               *ebiten.Shader
+	radialBlurShaderOpts *ebiten.DrawRectShaderOptions
+
+	colormodShader *ebiten.Shader
+
+	radialGradientShader     *ebiten.Shader
+	radialGradientShaderOpts *ebiten.DrawRectShaderOptions
+
+	// noteIndex finds the notes near the screen so the rest aren't drawn, rebuilt whenever notes changes
+	noteIndex *noteIndex
+	// visibleNotes holds the notes drawn in the current frame, reused between frames
+	visibleNotes []indexedRenderable
+	// nearbyNotes holds the notes found by forNotesBetween, reused between calls
+	nearbyNotes []indexedRenderable
+
+	// offscreen images reused every frame instead of allocating new textures, see offscreenImage
+	baseImage    *ebiten.Image
+	blurImage    *ebiten.Image
+	stretchImage *ebiten.Image
+
+	playerPosition time.Duration
+	player         *audio.Player
+	// wasPlaying is set while the player drives the timing, used to hand off to the tick clock when the audio ends
+	wasPlaying bool
+	// useTickClock times the visuals with the tick clock even while the audio plays
+	useTickClock bool
+	// waveform is nil unless the waveform strip is enabled
+	waveform *Waveform
+	// minimap is nil unless the minimap is enabled
+	minimap *Minimap
+	// spectrum is nil unless the spectrum is enabled
+	spectrum *Spectrum
+	// vuMeter is nil unless the VU meter is enabled
+	vuMeter *VUMeter
+
+	opts Options
+
+	// scale is the device scale factor, offscreen images are scaled by it for high-DPI displays
+	scale float32
+
+	// paused stops time from advancing, toggled with space and set when reaching a breakpoint
+	paused bool
+
+	// loopStart and loopEnd are the measures looped over, there's no loop unless loopEnd is after loopStart
+	loopStart int
+	loopEnd   int
+
+	// lastElapsedDeltaTime is the elapsedDeltaTime of the previous update, used to detect note ons
+	lastElapsedDeltaTime int
+	// seeked is set when the playhead jumped to lastElapsedDeltaTime rather than played up to it, at the start and on seeks,
+	// so the next note events include the ones on that tick
+	seeked bool
+	// shakeAmount is the current camera shake offset in pixels, decays every frame
+	shakeAmount float64
+
+	// noteEvents are the note ons and offs sent to OnNoteEvent and OSC, in tick order
+	noteEvents []noteEvent
+	// osc is nil unless OSCAddress is set
+	osc *oscClient
+
+	// drawHooks draw custom overlays at the end of every frame
+	drawHooks []DrawHook
+
+	// legend has an entry for each track and channel with notes
+	legend []legendEntry
+
+	// hoveredNote is the note under the cursor, set by the renderers while drawing
+	hoveredNote *Note
+
+	// hud draws the transport readout, toggled with H
+	hud bool
+	// timeSignatures are the time signature changes of every track in tick order, used by the HUD
+	timeSignatures []TimeSignatureChange
+
+	// outsideWidth and outsideHeight are the window's logical size, the screen is stretched over it when Stretch is set
+	outsideWidth  int
+	outsideHeight int
+
+	// quality is the current adaptive quality level, QualityFull unless TargetFPS is set
+	quality int
+	// qualityCooldown is the number of updates to wait before changing the quality level again
+	qualityCooldown int
+
+	// rng is seeded by the Seed option
+	rng *rand.Rand
+
+	// titleCooldown is the number of updates to wait before updating the window title again
+	titleCooldown int
+
+	// idleUpdates is the number of updates since the last input, used by the demo mode
+	idleUpdates int
+	// demoSwitches is the number of times the demo mode switched note types
+	demoSwitches int
+	// lastCursorX and lastCursorY are the cursor position of the previous update, moving the cursor counts as input
+	lastCursorX int
+	lastCursorY int
+
+	// exportFrame is the index of the frame being exported when ExportDir is set
+	exportFrame int
+	// exportFrameWritten is set once exportFrame is written, the next update moves on to the next frame
+	exportFrameWritten bool
+	// exportErr is the error writing the last frame, returned by the next update
+	exportErr error
+}
+
+func (g *Game) Update() error {
+	// Escape quits, returning from Run like closing the window so deferred cleanup still runs
+	if inpututil.IsKeyJustPressed(ebiten.KeyEscape) {
+		return ebiten.Termination
+	}
+
+	// T forces the tick clock while the audio plays, for comparing the two clocks when debugging sync
+	if inpututil.IsKeyJustPressed(ebiten.KeyT) {
+		g.useTickClock = !g.useTickClock
+	}
+
+	// space pauses and resumes playback, the audio and the tick clock continue from the same position
+	if g.opts.ExportDir == "" && inpututil.IsKeyJustPressed(ebiten.KeySpace) {
+		g.paused = !g.paused
+		if g.paused {
+			g.pauseAudio()
+		} else {
+			g.playAudio()
+		}
+	}
+
+	if g.opts.ExportDir != "" {
+		// exported frames are timed by their frame index rather than a clock
+		if err := g.updateExport(); err != nil {
+			return err
+		}
+	} else if g.paused {
+		// time doesn't advance until resumed
+		if err := g.updateStepFrame(); err != nil {
+			return err
+		}
+	} else if g.audioPlaying() && !g.useTickClock {
+		g.wasPlaying = true
+		g.playerPosition = g.player.Position()
+		// the midi starts AudioStart into the audio
+		songPosition := max(g.playerPosition-g.audioStart(), 0)
+		g.elapsedDeltaTime = g.tempoMap.secondsToTick(float64(songPosition.Milliseconds()) / 1000.0)
+	} else {
+		// the audio ended before the midi or the tick clock was forced,
+		// continue the tick clock from where the audio clock stopped instead of the start
+		if g.wasPlaying {
+			g.wasPlaying = false
+			g.currentTick = g.tempoMap.tickToSeconds(g.elapsedDeltaTime) * float64(g.opts.FPS)
+		}
+
+		// If not playing, just use ticks to track time
+		g.currentTick += g.playbackRate
+		// convert screen render ticks (g.currentTick) to midi ticks
+		// Each screen tick is 1/FPS of a second, matching the TPS set in startRender
+		g.elapsedDeltaTime = g.tempoMap.secondsToTick(g.currentTick * (1.0 / float64(g.opts.FPS)))
+
+	}
+
+	g.playerMeasure = g.tickToMeasure(g.elapsedDeltaTime)
+
+	g.updateBreakpoints()
+	if err := g.updateLoop(); err != nil {
+		return err
+	}
+	if err := g.updatePlaybackRate(); err != nil {
+		return err
+	}
+	g.updateShake()
+	g.updateQuality()
+	g.updateTitle()
+	g.updateHUD()
+	g.updateNoteEvents()
+	if err := g.updateDemo(); err != nil {
+		return err
+	}
+	g.lastElapsedDeltaTime = g.elapsedDeltaTime
+
+	shiftPressed := ebiten.IsKeyPressed(ebiten.KeyShift)
+
+	// if right key just released, seek a bit
+	if inpututil.IsKeyJustPressed(ebiten.KeyRight) && !shiftPressed {
+		err := g.seekToMeasure(g.playerMeasure + 1)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// left seeks back to the start of the previous measure, for re-watching a transition
+	if inpututil.IsKeyJustPressed(ebiten.KeyLeft) && !shiftPressed {
+		if err := g.seekToMeasure(max(g.playerMeasure-1, 0)); err != nil {
+			return err
+		}
+	}
+
+	// shift+left/right seeks by beat, B snaps to the nearest beat
+	currentBeat := g.elapsedDeltaTime / g.ppqn
+	if inpututil.IsKeyJustPressed(ebiten.KeyRight) && shiftPressed {
+		if err := g.seekToTick((currentBeat + 1) * g.ppqn); err != nil {
+			return err
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyLeft) && shiftPressed {
+		if err := g.seekToTick((currentBeat - 1) * g.ppqn); err != nil {
+			return err
+		}
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyB) {
+		if err := g.seekToTick(quantizeTick(g.elapsedDeltaTime, g.ppqn)); err != nil {
+			return err
+		}
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyHome) {
+		if err := g.restart(); err != nil {
+			return err
+		}
+	}
+
+	if g.waveform != nil {
+		if err := g.waveform.Update(g); err != nil {
+			return err
+		}
+	}
+	if g.minimap != nil {
+		if err := g.minimap.Update(g); err != nil {
+			return err
+		}
+	}
+	if g.spectrum != nil {
+		if err := g.spectrum.Update(g); err != nil {
+			return err
+		}
+	}
+	if g.vuMeter != nil {
+		if err := g.vuMeter.Update(g); err != nil {
+			return err
+		}
+	}
+
+	// Update shader uniforms
+	g.radialGradientShaderOpts.Uniforms["PctShow"] = 0
+
+	cx, cy := ebiten.CursorPosition()
+	g.radialBlurShaderOpts.Uniforms["Time"] = float32(g.currentTick) / float32(g.opts.FPS)
+	g.radialBlurShaderOpts.Uniforms["Cursor"] = []float32{float32(cx), float32(cy)}
+	g.radialBlurShaderOpts.Uniforms["Strength"] = g.blurStrength()
+
+	return nil
+}
+
+// blurStrength returns the strength of the radial blur, BlurStrength plus BlurSensitivity
+// for every full velocity note playing, so the blur pulses with the music
+func (g *Game) blurStrength() float32 {
+	if g.opts.BlurSensitivity == 0 {
+		return float32(g.opts.BlurStrength)
+	}
+
+	return float32(g.opts.BlurStrength + g.opts.BlurSensitivity*g.playingActivity())
+}
+
+// playingActivity is the total velocity of the notes playing at the playhead, counting full velocity notes as 1
+func (g *Game) playingActivity() float64 {
+	activity := 0.0
+	g.forNotesBetween(g.elapsedDeltaTime, g.elapsedDeltaTime, func(n Note) {
+		if n.on <= g.elapsedDeltaTime && g.elapsedDeltaTime <= n.off {
+			activity += float64(n.vel) / 127
+		}
+	})
+	return activity
+}
+
+// updateBreakpoints pauses playback when the playhead crosses the start of a breakpoint measure.
+// Crossing is checked against the previous update, so each breakpoint only triggers once per pass.
+func (g *Game) updateBreakpoints() {
+	if g.paused {
+		return
+	}
+
+	for _, measure := range g.opts.Breakpoints {
+		breakTick := measure * g.ppqn * 4
+		if g.lastElapsedDeltaTime < breakTick && breakTick <= g.elapsedDeltaTime {
+			g.paused = true
+			g.pauseAudio()
+			return
+		}
+	}
+}
+
+// updateTitle appends the current measure and time to the window title when TitlePosition is set.
+// Setting the title is relatively slow on some platforms, so it's only updated once a second.
+func (g *Game) updateTitle() {
+	if !g.opts.TitlePosition {
+		return
+	}
+
+	if g.titleCooldown > 0 {
+		g.titleCooldown--
+		return
+	}
+	g.titleCooldown = g.opts.FPS
+
+	elapsed := time.Duration(g.tempoMap.tickToSeconds(g.elapsedDeltaTime) * float64(time.Second))
+	ebiten.SetWindowTitle(fmt.Sprintf("%s - measure %d (%s)", g.opts.Title, g.playerMeasure, elapsed.Truncate(time.Second)))
+}
+
+// demoSeekMeasures is the number of measures the demo mode skips ahead each time it advances
+const demoSeekMeasures = 8
+
+// updateDemo advances the demo after DemoIdle seconds without input, switching every note to the next
+// note type and skipping ahead, wrapping to the start at the end of the song.
+// There's no playlist, so the demo stays on the current song.
+func (g *Game) updateDemo() error {
+	if g.opts.DemoIdle <= 0 {
+		return nil
+	}
+
+	cx, cy := ebiten.CursorPosition()
+	cursorMoved := cx != g.lastCursorX || cy != g.lastCursorY
+	g.lastCursorX, g.lastCursorY = cx, cy
+	mousePressed := inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) || inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonRight)
+	keyPressed := len(inpututil.AppendJustPressedKeys(nil)) > 0
+	if cursorMoved || mousePressed || keyPressed {
+		g.idleUpdates = 0
+		return nil
+	}
+
+	g.idleUpdates++
+	if float64(g.idleUpdates) < g.opts.DemoIdle*float64(g.opts.FPS) {
+		return nil
+	}
+	g.idleUpdates = 0
+
+	g.setNoteType(noteTypes[g.demoSwitches%len(noteTypes)])
+	g.demoSwitches++
+
+	nextMeasure := g.playerMeasure + demoSeekMeasures
+	if nextMeasure*g.ppqn*4 >= g.songEndTick {
+		nextMeasure = 0
+	}
+
+	return g.seekToMeasure(nextMeasure)
+}
+
+// setNoteType replaces the renderables of every note with ones of the note type, keeping their color and z.
+// Chords are split back into their notes, then each track is set up like newGame does
+func (g *Game) setNoteType(noteType int) {
+	// contours, chords and ties are linked within a track, the lane of a note is its track
+	trackNotes := make([][]Renderable, len(g.tracks))
+	for _, r := range g.notes {
+		for _, n := range renderedNotes(r) {
+			trackNotes[n.lane] = append(trackNotes[n.lane], newRenderable(noteType, n, r.GetZ(), r.GetColor(), len(trackNotes[n.lane])))
+		}
+	}
+
+	notes := make([]Renderable, 0, len(g.notes))
+	for trackIndex, renderables := range trackNotes {
+		fileConfig := g.opts.Config.forFile(g.tracks[trackIndex].name)
+		notes = append(notes, setupTrackNotes(renderables, fileConfig, g.opts.Ties)...)
+	}
+	sort.SliceStable(notes, func(i, j int) bool {
+		return notes[i].GetZ() < notes[j].GetZ()
+	})
+	g.notes = notes
+	g.noteIndex = newNoteIndex(notes)
+}
+
+// updateLoop sets the loop points with I and O and seeks back to the loop start once the loop end is reached.
+// The loop covers the measures from loopStart up to, but not including, loopEnd.
+func (g *Game) updateLoop() error {
+	if inpututil.IsKeyJustPressed(ebiten.KeyI) {
+		g.loopStart = g.playerMeasure
+	}
+	// the out point includes the current measure
+	if inpututil.IsKeyJustPressed(ebiten.KeyO) {
+		g.loopEnd = g.playerMeasure + 1
+	}
+
+	if g.loopEnd <= g.loopStart {
+		return nil
+	}
+
+	if g.playerMeasure >= g.loopEnd {
+		return g.seekToMeasure(g.loopStart)
+	}
+
+	return nil
+}
+
+// Range of the playback rate, stepped by factors of 2 from the normal speed
+const (
+	minPlaybackRate = 0.25
+	maxPlaybackRate = 4.0
+)
+
+// updatePlaybackRate halves the playback rate with [ and doubles it with ], for inspecting the animations slowed down.
+// Only the visuals scale: the audio isn't resampled, so it's paused while the rate isn't 1 and the tick clock times the visuals.
+// Going back to the normal rate resumes the audio from the playhead
+func (g *Game) updatePlaybackRate() error {
+	rate := g.playbackRate
+	if inpututil.IsKeyJustPressed(ebiten.KeyBracketLeft) {
+		rate = max(rate/2, minPlaybackRate)
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyBracketRight) {
+		rate = min(rate*2, maxPlaybackRate)
+	}
+	if rate == g.playbackRate {
+		return nil
+	}
+
+	g.playbackRate = rate
+	if rate != 1 {
+		g.pauseAudio()
+		return nil
+	}
+
+	if err := g.seekToTick(g.elapsedDeltaTime); err != nil {
+		return err
+	}
+	if !g.paused {
+		g.playAudio()
+	}
+	return nil
+}
+
+// updateShake decays the camera shake and restarts it when a loud enough note of the shake file turns on
+func (g *Game) updateShake() {
+	if g.opts.ShakeIntensity <= 0 {
+		return
+	}
+
+	g.shakeAmount *= g.opts.ShakeDecay
+
+	g.forNotesBetween(g.lastElapsedDeltaTime+1, g.elapsedDeltaTime, func(note Note) {
+		if g.tracks[note.lane].name != g.opts.ShakeFile {
+			return
+		}
+		turnedOn := g.lastElapsedDeltaTime < note.on && note.on <= g.elapsedDeltaTime
+		if turnedOn && note.vel >= g.opts.ShakeVelocity {
+			g.shakeAmount = g.opts.ShakeIntensity
+		}
+	})
+}
+
+// applyShake sets the transform of the final composite to the current camera shake offset.
+// The composite is scaled up slightly around the center so the offset never reveals the edges.
+func (g *Game) applyShake(geoM *ebiten.GeoM) {
+	geoM.Reset()
+	if g.opts.ShakeIntensity <= 0 {
+		return
+	}
+
+	angle := g.rng.Float64() * 2 * math.Pi
+	dx := math.Cos(angle) * g.shakeAmount
+	dy := math.Sin(angle) * g.shakeAmount
+
+	// the offset is in logical pixels but the composite is drawn at device pixels
+	dx *= float64(g.scale)
+	dy *= float64(g.scale)
+
+	w, h := g.scaledSize()
+	scale := 1 + 2*g.opts.ShakeIntensity/float64(min(g.width, g.height))
+	geoM.Translate(-float64(w)/2, -float64(h)/2)
+	geoM.Scale(scale, scale)
+	geoM.Translate(float64(w)/2+dx, float64(h)/2+dy)
+}
+
+// Adaptive quality levels, each level also includes the degradations of the levels before it
+const (
+	QualityFull = iota
+	// QualityNoAntialias disables antialiasing of the note shapes
+	QualityNoAntialias
+	// QualityNoBlur skips the radial blur pass
+	QualityNoBlur
+	// QualityLoudNotes only renders notes with a velocity of at least lowQualityVelocityMin
+	QualityLoudNotes
+)
+
+// lowQualityVelocityMin is the minimum velocity of notes rendered at QualityLoudNotes
+const lowQualityVelocityMin = 64
+
+// updateQuality lowers the quality level while the actual FPS is below the target FPS and restores it once it recovers
+func (g *Game) updateQuality() {
+	if g.opts.TargetFPS <= 0 {
+		return
+	}
+
+	if g.qualityCooldown > 0 {
+		g.qualityCooldown--
+		return
+	}
+
+	// ActualFPS is averaged over a second, so wait that long for a change to show up before changing again
+	fps := ebiten.ActualFPS()
+	if fps < g.opts.TargetFPS*0.9 && g.quality < QualityLoudNotes {
+		g.quality++
+		g.qualityCooldown = ebiten.TPS()
+	} else if fps >= g.opts.TargetFPS*0.98 && g.quality > QualityFull {
+		g.quality--
+		g.qualityCooldown = ebiten.TPS()
+	}
+}
+
+// lanePaddingPixels is the padding at the top and bottom of each track's lane
+const lanePaddingPixels = 4
+
+// noteLane is the part of the screen a track's notes are drawn in when Lanes is set
+type noteLane struct {
+	top    float32
+	height float32
+	// noteMin and noteMax are the range of the track's notes, fit to the lane's height
+	noteMin int
+	noteMax int
+}
+
+// row returns the top and height of the note's row within the lane, before the gap is applied
+func (l noteLane) row(n Note) (float32, float32) {
+	rowHeight := (l.height - lanePaddingPixels*2) / float32(l.noteMax-l.noteMin+1)
+	// flip b/c we draw from upper left corner
+	return l.top + l.height - lanePaddingPixels - rowHeight*float32(n.num-l.noteMin+1), rowHeight
+}
+
+// noteRow returns the top and height of the note's row. The row is shrunk by Gap, centered on the pitch's lane,
+// so notes of adjacent pitches don't touch
+func (g *Game) noteRow(n Note) (float32, float32) {
+	// flip b/c we draw from upper left corner
+	noteY := float32(g.height - (g.noteHeight*(n.num-g.noteMin) + g.noteTopBottomPaddingPixels))
+	rowHeight := float32(g.noteHeight)
+	if g.lanes != nil {
+		noteY, rowHeight = g.lanes[n.lane].row(n)
+	}
+	gap := min(max(float32(g.opts.Gap), 0), rowHeight-1)
+
+	return noteY + gap/2, rowHeight - gap
+}
+
+// contourY returns the vertical center of the note's row, where contour lines connect
+func (g *Game) contourY(n Note) float32 {
+	noteY, noteHeight := g.noteRow(n)
+	return noteY + noteHeight/2
+}
+
+// panOffset returns the horizontal offset of a note from its channel's pan, negative is left
+func (g *Game) panOffset(n Note) float32 {
+	return float32(n.pan-centerPan) / centerPan * float32(g.opts.PanWidth)
+}
+
+// quantizeTick snaps a tick to the nearest multiple of gridTicks
+func quantizeTick(tick int, gridTicks int) int {
+	if gridTicks <= 0 {
+		return tick
+	}
+
+	return int(math.Round(float64(tick)/float64(gridTicks))) * gridTicks
+}
+
+// displayTicks returns the note on and off the note is drawn at, snapped to the QuantizeDisplay grid when it's set.
+// Notes shorter than the grid are drawn a grid step long so they don't disappear
+func (g *Game) displayTicks(n Note) (int, int) {
+	if g.opts.QuantizeDisplay <= 0 {
+		return n.on, n.off
+	}
+
+	gridTicks := g.quantizeDisplayTicks()
+	on, off := quantizeTick(n.on, gridTicks), quantizeTick(n.off, gridTicks)
+	if off <= on {
+		off = on + gridTicks
+	}
+	return on, off
+}
+
+// quantizeDisplayTicks returns the ticks between the lines of the QuantizeDisplay grid, 0 without one
+func (g *Game) quantizeDisplayTicks() int {
+	if g.opts.QuantizeDisplay <= 0 {
+		return 0
+	}
+	return max(g.ppqn*4/g.opts.QuantizeDisplay, 1)
+}
+
+// dimColor scales the color by alpha, the color is premultiplied so every component is scaled
+func dimColor(c color.RGBA, alpha float64) color.RGBA {
+	return color.RGBA{
+		R: uint8(float64(c.R) * alpha),
+		G: uint8(float64(c.G) * alpha),
+		B: uint8(float64(c.B) * alpha),
+		A: uint8(float64(c.A) * alpha),
+	}
+}
+
+// accentPop is how much taller accented notes are drawn at their note on, shrinking back over accentBeats
+const (
+	accentPop   = 1.0
+	accentBeats = 0.25
+)
+
+// accentRow grows the row of an accented note for a short time after its note on, keeping it centered
+func (g *Game) accentRow(n Note, y, h float32) (float32, float32) {
+	if !n.accent {
+		return y, h
+	}
+
+	accentTicks := accentBeats * float64(g.ppqn)
+	t := float64(g.elapsedDeltaTime-n.on) / accentTicks
+	if t < 0 || t > 1 {
+		return y, h
+	}
+
+	grow := h * accentPop * float32(1-t)
+	return y - grow/2, h + grow
+}
+
+// overlapColor outlines notes tagged as overlapping another note of the same pitch and channel
+var overlapColor = colornames.Orange
+
+// drawOverlapOutline outlines the note in the warning color when it overlaps another note
+func (g *Game) drawOverlapOutline(screen *ebiten.Image, n Note, x, y, w, h float32) {
+	if !n.overlaps {
+		return
+	}
+
+	g.strokeRect(screen, x, y, w, h, 2, overlapColor)
+}
+
+// drawPreviewOutline draws a faint outline of a note during the leadIn ticks before it turns on,
+// fading in as the note on gets closer. Renderers that only draw while playing use it to warn of upcoming notes.
+func (g *Game) drawPreviewOutline(screen *ebiten.Image, n Note, leadIn int, x, y, w, h float32, clr *color.RGBA) {
+	if !g.opts.PreviewOutlines || leadIn <= 0 {
+		return
+	}
+
+	ticksUntilOn := n.on - g.elapsedDeltaTime
+	if ticksUntilOn <= 0 || ticksUntilOn > leadIn {
+		return
+	}
+
+	pctUntilOn := 1 - float64(ticksUntilOn)/float64(leadIn)
+	strokeWidth := float32(1)
+	g.strokeRect(screen, x, y, w, h, strokeWidth, dimColor(*clr, 0.1+0.3*pctUntilOn))
+}
+
+// tickToMeasure returns the measure containing the tick, assuming 4/4
+func (g *Game) tickToMeasure(tick int) int {
+	return tick / (g.ppqn * 4)
+}
+
+// cursorPosition returns the cursor position in logical coordinates
+func (g *Game) cursorPosition() (float32, float32) {
+	cx, cy := ebiten.CursorPosition()
+	x, y := float32(cx)/g.scale, float32(cy)/g.scale
+	if g.opts.Stretch && g.outsideWidth > 0 && g.outsideHeight > 0 {
+		// the cursor is in window coordinates, undo the stretch
+		x *= float32(g.width) / float32(g.outsideWidth)
+		y *= float32(g.height) / float32(g.outsideHeight)
+	}
+	return x, y
+}
+
+// drawNoteInspector draws a tooltip describing the hovered note next to the cursor
+func (g *Game) drawNoteInspector(screen *ebiten.Image) {
+	if g.hoveredNote == nil {
+		return
+	}
+
+	n := g.hoveredNote
+	info := fmt.Sprintf(
+		"note: %s (%d)\nvelocity: %d\nmeasures: %d - %d\nchannel: %d\ninstrument: %s",
+		n.str, n.num, n.vel, g.tickToMeasure(n.on), g.tickToMeasure(n.off), n.channel, instrumentName(n.channel, n.program),
+	)
+
+	// the debug font is 6x16 pixels per character at device pixels
+	const charWidth, lineHeight, padding = 6, 16, 4
+	lines := strings.Split(info, "\n")
+	longestLine := 0
+	for _, line := range lines {
+		longestLine = max(longestLine, len(line))
+	}
+	boxWidth := longestLine*charWidth + padding*2
+	boxHeight := len(lines)*lineHeight + padding*2
+
+	// keep the box on screen
+	screenWidth, screenHeight := g.scaledSize()
+	// the box is drawn in device pixels, from the cursor's logical position so it follows the cursor when stretched
+	x, y := g.cursorPosition()
+	cx, cy := int(x*g.scale), int(y*g.scale)
+	boxX := min(cx+12, screenWidth-boxWidth)
+	boxY := min(cy+12, screenHeight-boxHeight)
+
+	vector.DrawFilledRect(screen, float32(boxX), float32(boxY), float32(boxWidth), float32(boxHeight), color.RGBA{0, 0, 0, 0xcc}, false)
+	ebitenutil.DebugPrintAt(screen, info, boxX+padding, boxY+padding)
+}
+
+// legendEntry is a channel of a track listed in the channel labels legend
+type legendEntry struct {
+	track   *Track
+	channel int
+	color   color.RGBA
+}
+
+// drawChannelLabels draws the legend of channel colors and their current instruments in the top left corner
+func (g *Game) drawChannelLabels(screen *ebiten.Image) {
+	if !g.opts.ChannelLabels {
+		return
+	}
+
+	// the debug font is 16 pixels per line at device pixels
+	const lineHeight, swatchSize, padding = 16, 10, 8
+	for i, entry := range g.legend {
+		instrument := entry.track.InstrumentAt(entry.channel, g.elapsedDeltaTime)
+
+		y := padding + i*lineHeight
+		vector.DrawFilledRect(screen, padding, float32(y+3), swatchSize, swatchSize, entry.color, false)
+		label := fmt.Sprintf("ch %d: %s (%s)", entry.channel, instrument, entry.track.name)
+		ebitenutil.DebugPrintAt(screen, label, padding+swatchSize+4, y)
+	}
+}
+
+// drawMeasureNumbers draws the number of each measure along the top of the screen at the measure's start,
+// scrolling with the notes. Measures are 4/4 like tickToMeasure
+func (g *Game) drawMeasureNumbers(screen *ebiten.Image) {
+	if !g.opts.MeasureNumbers {
+		return
+	}
+
+	ticksPerMeasure := g.ppqn * 4
+	// the first and last measures starting on screen
+	startTick, endTick := g.ticksOnScreen()
+	firstMeasure := max(startTick/ticksPerMeasure, 0)
+	lastMeasure := endTick / ticksPerMeasure
+	for m := firstMeasure; m <= lastMeasure; m++ {
+		x := float32(m*ticksPerMeasure-g.elapsedDeltaTime)*g.pixelsPerTick() + float32(g.xTranslate)
+		vector.StrokeLine(screen, x*g.scale, 0, x*g.scale, 12*g.scale, 1, colornames.Gray, false)
+		ebitenutil.DebugPrintAt(screen, fmt.Sprint(m), int(x*g.scale)+3, 0)
+	}
+}
+
+// audioStart returns how far into the audio file the midi starts
+func (g *Game) audioStart() time.Duration {
+	return time.Duration(g.opts.AudioStart * float64(time.Second))
+}
+
+// pixelsPerTick returns how many pixels the scrolling notes move per tick, set by PixelsPerBeat or 1 by default
+func (g *Game) pixelsPerTick() float32 {
+	if g.opts.PixelsPerBeat <= 0 {
+		return 1
+	}
+	return float32(g.opts.PixelsPerBeat) / float32(g.ppqn)
+}
+
+// ticksOnScreen returns the ticks at the left and right edges of the screen in the scrolling view
+func (g *Game) ticksOnScreen() (int, int) {
+	startTick := g.elapsedDeltaTime - int(float32(g.xTranslate)/g.pixelsPerTick())
+	return startTick, startTick + int(float32(g.width)/g.pixelsPerTick())
+}
+
+// seekToTime seeks to a specific time of the song, which is AudioStart into the audio file.
+// The playhead jumps along so seeks also work while the tick clock drives the timing, like when the audio has ended
+func (g *Game) seekToTime(t time.Duration) error {
+	// clicking the waveform before AudioStart seeks before the song, it starts at 0 like seekToTick clamps to
+	t = max(t, 0)
+	g.currentTick = t.Seconds() * float64(g.opts.FPS)
+	g.elapsedDeltaTime = g.tempoMap.secondsToTick(t.Seconds())
+	// a seek jumps rather than plays through the ticks in between, so breakpoints and note ons skipped over don't trigger
+	g.lastElapsedDeltaTime = g.elapsedDeltaTime
+	g.seeked = true
+	g.playerMeasure = g.tickToMeasure(g.elapsedDeltaTime)
+
+	if g.player == nil {
+		return nil
+	}
+	if err := g.player.SetPosition(t + g.audioStart()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// seekToTick seeks to a tick, clamped between the start and end of the song
+func (g *Game) seekToTick(tick int) error {
+	tick = min(max(tick, 0), g.songEndTick)
+	t := g.tempoMap.tickToSeconds(tick)
+	nanoSec := int64(t * 1000000000)
+
+	if err := g.seekToTime(time.Duration(nanoSec)); err != nil {
+		return err
+	}
+	// keep the exact tick rather than its round trip through seconds
+	g.elapsedDeltaTime = tick
+	g.lastElapsedDeltaTime = tick
+	g.playerMeasure = g.tickToMeasure(tick)
+	return nil
+}
+
+// updateStepFrame steps the paused playhead a frame forward with . or back with , for inspecting the animations.
+// The audio is seeked along so resuming continues from the stepped frame
+func (g *Game) updateStepFrame() error {
+	step := 0
+	if inpututil.IsKeyJustPressed(ebiten.KeyPeriod) {
+		step = 1
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyComma) {
+		step = -1
+	}
+	if step == 0 {
+		return nil
+	}
+
+	// the audio clock may have been driving the playhead, so step from the playhead's frame rather than currentTick
+	frame := int64(math.Round(g.tempoMap.tickToSeconds(g.elapsedDeltaTime) * float64(g.opts.FPS)))
+	frame = max(frame+int64(step), 0)
+	return g.seekToTick(g.tempoMap.secondsToTick(float64(frame) / float64(g.opts.FPS)))
+}
+
+// restart seeks back to the start of the song and resumes playing, resetting the effects
+func (g *Game) restart() error {
+	if err := g.seekToTime(0); err != nil {
+		return err
+	}
+
+	g.currentTick = 0
+	g.elapsedDeltaTime = 0
+	g.lastElapsedDeltaTime = 0
+	g.playerMeasure = 0
+	g.shakeAmount = 0
+	g.radialGradientShaderOpts.Uniforms["PctShow"] = 0
+
+	g.paused = false
+	g.playAudio()
+
+	return nil
+}
+
+// audioPlaying reports whether the audio is playing, always false without audio
+func (g *Game) audioPlaying() bool {
+	return g.player != nil && g.player.IsPlaying()
+}
+
+// playAudio resumes the audio if there is any, it only plays at the normal playback rate
+func (g *Game) playAudio() {
+	if g.player != nil && !g.player.IsPlaying() && g.playbackRate == 1 {
+		g.player.Play()
+	}
+}
+
+// pauseAudio pauses the audio if there is any
+func (g *Game) pauseAudio() {
+	if g.player != nil {
+		g.player.Pause()
+	}
+}
+
+// seekToMeasure seeks to a specific measure in the audio file
+func (g *Game) seekToMeasure(m int) error {
+	return g.seekToTick(m * g.ppqn * 4)
+}
+
+func (g *Game) Draw(screen *ebiten.Image) {
+	if !g.opts.Stretch {
+		g.drawFrame(screen)
+		return
+	}
+
+	// draw at the logical size, then stretch it over the whole window
+	w, h := g.scaledSize()
+	frame := offscreenImage(&g.stretchImage, w, h)
+	g.drawFrame(frame)
+
+	opts := &ebiten.DrawImageOptions{}
+	opts.GeoM.Scale(float64(screen.Bounds().Dx())/float64(w), float64(screen.Bounds().Dy())/float64(h))
+	opts.Filter = ebiten.FilterLinear
+	screen.DrawImage(frame, opts)
+}
+
+// cullRange returns the ticks of the notes that may draw on screen, the notes outside aren't drawn.
+// It's the scrolling view's ticks widened by the echoes trailing behind notes, the animations' lead-ins,
+// the pixels notes may be offset or grown by and the grid step notes may be snapped by
+func (g *Game) cullRange() (int, int) {
+	start, end := g.ticksOnScreen()
+
+	marginPixels := g.opts.PanWidth + g.opts.MinNoteSize + g.opts.StrokeWidth
+	margin := int(float32(marginPixels)/g.pixelsPerTick()) + 1
+	echoTrail := int(float64(g.opts.Echoes) * echoSpacingBeats * float64(g.ppqn))
+	leadIn := max(g.opts.Config.leadInTicks(NoteTypeMeter, g.ppqn), g.opts.Config.leadInTicks(NoteTypeZoom, g.ppqn))
+	// the notes are culled by their ticks but drawn at their displayTicks, up to a grid step away
+	margin += g.quantizeDisplayTicks()
+
+	return start - margin - echoTrail, end + margin + leadIn
+}
+
+// forNotesBetween calls fn with the notes of the renderables that may be on between the ticks start and end,
+// found with the note index rather than going through every note
+func (g *Game) forNotesBetween(start, end int, fn func(n Note)) {
+	g.nearbyNotes = g.noteIndex.overlapping(start, end, g.nearbyNotes[:0])
+	for _, indexed := range g.nearbyNotes {
+		for _, n := range renderedNotes(indexed.r) {
+			fn(n)
+		}
+	}
+}
+
+// offscreenImage returns the cached image cleared for a new frame, allocating it on first use
+// and again when the size changes, like when the window moves to a monitor with another scale factor
+func offscreenImage(img **ebiten.Image, w, h int) *ebiten.Image {
+	if *img != nil && (*img).Bounds().Dx() == w && (*img).Bounds().Dy() == h {
+		(*img).Clear()
+		return *img
+	}
+
+	if *img != nil {
+		(*img).Deallocate()
+	}
+	*img = ebiten.NewImage(w, h)
+	return *img
+}
+
+// drawFrame draws the notes and overlays to a screen sized image
+func (g *Game) drawFrame(screen *ebiten.Image) {
+	// offscreen images are allocated at device pixels so strokes stay crisp on high-DPI displays,
+	// the renderers keep using logical coordinates and draw through fillRect and strokeRect
+	w, h := g.scaledSize()
+	baseImage := offscreenImage(&g.baseImage, w, h)
+	g.hoveredNote = nil
+	if g.spectrum != nil {
+		g.spectrum.Draw(baseImage, g)
+	}
+	start, end := g.cullRange()
+	g.visibleNotes = g.noteIndex.overlapping(start, end, g.visibleNotes[:0])
+	for _, visible := range g.visibleNotes {
+		note := visible.r
+		if g.quality >= QualityLoudNotes && note.GetNote().vel < lowQualityVelocityMin {
+			continue
+		}
+		note.Draw(baseImage, g)
+	}
+
+	if g.opts.NoBlur {
+		// composite the notes directly, the shader options don't keep a reference to this frame's image
+		op := &ebiten.DrawImageOptions{}
+		g.applyShake(&op.GeoM)
+		screen.DrawImage(baseImage, op)
+	} else {
+		if g.quality >= QualityNoBlur {
+			g.radialGradientShaderOpts.Images[0] = baseImage
+		} else {
+			// set before drawing, a reallocated base image would leave the options with a deallocated one
+			g.radialBlurShaderOpts.Images[0] = baseImage
+			blurImage := offscreenImage(&g.blurImage, w, h)
+			blurImage.DrawRectShader(w, h, g.shader, g.radialBlurShaderOpts)
+
+			g.radialGradientShaderOpts.Images[0] = blurImage
+		}
+
+		g.applyShake(&g.radialGradientShaderOpts.GeoM)
+		screen.DrawRectShader(w, h, g.radialGradientShader, g.radialGradientShaderOpts)
+	}
+
+	if g.waveform != nil {
+		g.waveform.Draw(screen, g)
+	}
+	if g.minimap != nil {
+		g.minimap.Draw(screen, g)
+	}
+	if g.vuMeter != nil {
+		g.vuMeter.Draw(screen, g)
+	}
+
+	g.drawMeasureNumbers(screen)
+	g.drawChannelLabels(screen)
+	g.drawNoteInspector(screen)
+	g.drawHUD(screen)
+
+	measurePosition := g.elapsedDeltaTime / (g.ppqn * 4)
+	if g.opts.Debug {
+		ebitenutil.DebugPrint(screen, fmt.Sprintf("playerPosition: %d\nmeasurePosition: %d", g.playerPosition, measurePosition))
+	}
+
+	for _, hook := range g.drawHooks {
+		hook(screen, g)
+	}
+
+	if g.opts.ExportDir != "" {
+		g.writeExportFrame(screen)
+	}
+}
+
+func (g *Game) Layout(outsideWidth, outsideHeight int) (screenWidth, screenHeight int) {
+	// layout stays at the logical size, scaled by the monitor's device scale factor,
+	// ebiten letterboxes it when the window's aspect ratio differs
+	g.scale = float32(ebiten.Monitor().DeviceScaleFactor())
+	if g.opts.Stretch {
+		g.outsideWidth, g.outsideHeight = outsideWidth, outsideHeight
+		return int(float32(outsideWidth) * g.scale), int(float32(outsideHeight) * g.scale)
+	}
+	return g.scaledSize()
+}
+
+// Scale returns the device scale factor the screen is drawn at, multiply logical coordinates by it to get device pixels
+func (g *Game) Scale() float32 {
+	return g.scale
+}
+
+// scaledSize returns the logical screen size in device pixels
+func (g *Game) scaledSize() (int, int) {
+	return int(float32(g.width) * g.scale), int(float32(g.height) * g.scale)
+}
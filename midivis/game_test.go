@@ -0,0 +1,172 @@
+package midivis
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+func TestDisplayTicks(t *testing.T) {
+	tests := []struct {
+		name            string
+		quantizeDisplay int
+		on, off         int
+		wantOn, wantOff int
+	}{
+		{"no grid", 0, 10, 50, 10, 50},
+		// 16th notes are 24 ticks at 96 ppqn
+		{"snapped", 16, 10, 50, 0, 48},
+		{"snapped up", 16, 20, 70, 24, 72},
+		{"shorter than the grid", 16, 30, 33, 24, 48},
+		{"zero length", 16, 24, 24, 24, 48},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := &Game{ppqn: 96, opts: Options{QuantizeDisplay: test.quantizeDisplay}}
+			on, off := g.displayTicks(Note{on: test.on, off: test.off})
+			if on != test.wantOn || off != test.wantOff {
+				t.Errorf("got %d-%d, want %d-%d", on, off, test.wantOn, test.wantOff)
+			}
+		})
+	}
+}
+
+func TestSeekToMeasure(t *testing.T) {
+	const ppqn = 96
+	tempoMaps := map[string]TempoMap{
+		"default tempo": {ppqn: ppqn},
+		// a tempo change in the middle of a measure, the tick doesn't round trip through seconds exactly
+		"tempo change": {ppqn: ppqn, changes: []TempoChange{{tick: 500, microSecondsPerQuarterNote: 333333}}},
+	}
+	for name, tempoMap := range tempoMaps {
+		t.Run(name, func(t *testing.T) {
+			g := &Game{ppqn: ppqn, tempoMap: tempoMap, songEndTick: 100 * ppqn * 4, opts: Options{FPS: 60}}
+			for _, measure := range []int{3, 1, 7} {
+				if err := g.seekToMeasure(measure); err != nil {
+					t.Fatal(err)
+				}
+				if want := measure * ppqn * 4; g.elapsedDeltaTime != want {
+					t.Errorf("measure %d: elapsedDeltaTime %d, want %d", measure, g.elapsedDeltaTime, want)
+				}
+				if g.playerMeasure != measure {
+					t.Errorf("measure %d: playerMeasure %d", measure, g.playerMeasure)
+				}
+				// the tick clock continues from the seek
+				wantTick := tempoMap.tickToSeconds(measure*ppqn*4) * float64(g.opts.FPS)
+				if math.Abs(g.currentTick-wantTick) > 1e-6 {
+					t.Errorf("measure %d: currentTick %v, want %v", measure, g.currentTick, wantTick)
+				}
+				if g.lastElapsedDeltaTime != g.elapsedDeltaTime {
+					t.Errorf("measure %d: lastElapsedDeltaTime %d, a seek isn't a crossing", measure, g.lastElapsedDeltaTime)
+				}
+			}
+		})
+	}
+}
+
+func TestSeekToMeasureClamped(t *testing.T) {
+	g := &Game{ppqn: 96, tempoMap: TempoMap{ppqn: 96}, songEndTick: 1000, opts: Options{FPS: 60}}
+	if err := g.seekToMeasure(100); err != nil {
+		t.Fatal(err)
+	}
+	if g.elapsedDeltaTime != g.songEndTick {
+		t.Errorf("elapsedDeltaTime %d, want the song end %d", g.elapsedDeltaTime, g.songEndTick)
+	}
+}
+
+func BenchmarkOffscreenImage(b *testing.B) {
+	// the baseline, a new image every frame like before the images were reused
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			ebiten.NewImage(defaultWidth, defaultHeight)
+		}
+	})
+	b.Run("reused", func(b *testing.B) {
+		var img *ebiten.Image
+		offscreenImage(&img, defaultWidth, defaultHeight)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			offscreenImage(&img, defaultWidth, defaultHeight)
+		}
+	})
+	// a new size every frame allocates every time, for comparison
+	b.Run("resized", func(b *testing.B) {
+		var img *ebiten.Image
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			offscreenImage(&img, defaultWidth+i%2, defaultHeight)
+		}
+	})
+}
+
+func TestSetNoteTypeContour(t *testing.T) {
+	track := NewTrack("test.mid", 96)
+	track.notes = []Note{{on: 0, off: 96, num: 60}, {on: 96, off: 192, num: 64}, {on: 192, off: 288, num: 62}}
+	g := &Game{ppqn: 96, tracks: []*Track{track}, opts: Options{Config: &RenderConfig{}}}
+	for i, n := range track.notes {
+		g.notes = append(g.notes, newRenderable(NoteTypeRect, n, 0, &defaultPalette[0], i))
+	}
+
+	g.setNoteType(NoteTypeContour)
+	if len(g.notes) != len(track.notes) {
+		t.Fatalf("%d renderables, want %d", len(g.notes), len(track.notes))
+	}
+	// every contour but the last links to the next note, like in newGame
+	for i, r := range g.notes {
+		contour, ok := r.(*NoteContour)
+		if !ok {
+			t.Fatalf("renderable %d is a %T", i, r)
+		}
+		if i == len(g.notes)-1 {
+			if contour.next != nil {
+				t.Errorf("last contour links to %+v", *contour.next)
+			}
+			continue
+		}
+		if contour.next == nil || contour.next.on != track.notes[i+1].on {
+			t.Errorf("contour %d isn't linked to note %d", i, i+1)
+		}
+	}
+}
+
+func TestSeekToTimeClamped(t *testing.T) {
+	g := &Game{ppqn: 96, tempoMap: TempoMap{ppqn: 96}, songEndTick: 1000, opts: Options{FPS: 60, AudioStart: 2}}
+	if err := g.seekToTime(-g.audioStart()); err != nil {
+		t.Fatal(err)
+	}
+	if g.elapsedDeltaTime != 0 || g.currentTick != 0 {
+		t.Errorf("elapsedDeltaTime %d and currentTick %v, want 0", g.elapsedDeltaTime, g.currentTick)
+	}
+}
+
+func TestForNotesBetween(t *testing.T) {
+	notes := []Renderable{
+		newRenderable(NoteTypeRect, Note{on: 0, off: 96}, 0, &defaultPalette[0], 0),
+		newRenderable(NoteTypeRect, Note{on: 48, off: 200}, 0, &defaultPalette[0], 1),
+		newRenderable(NoteTypeRect, Note{on: 500, off: 600}, 0, &defaultPalette[0], 2),
+	}
+	g := &Game{notes: notes, noteIndex: newNoteIndex(notes)}
+
+	var found []int
+	g.forNotesBetween(90, 100, func(n Note) {
+		found = append(found, n.on)
+	})
+	// the index may return notes near the range, but never skips one in it
+	for _, want := range []int{0, 48} {
+		ok := false
+		for _, on := range found {
+			ok = ok || on == want
+		}
+		if !ok {
+			t.Errorf("note on %d not found in %v", want, found)
+		}
+	}
+	for _, on := range found {
+		if on == 500 {
+			t.Errorf("note on 500 found in %v", found)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package midivis
+
+// General MIDI level 1 instrument names, indexed by program number
+var gmInstrumentNames = [128]string{
+	// Piano
+	"Acoustic Grand Piano", "Bright Acoustic Piano", "Electric Grand Piano", "Honky-tonk Piano",
+	"Electric Piano 1", "Electric Piano 2", "Harpsichord", "Clavinet",
+	// Chromatic Percussion
+	"Celesta", "Glockenspiel", "Music Box", "Vibraphone",
+	"Marimba", "Xylophone", "Tubular Bells", "Dulcimer",
+	// Organ
+	"Drawbar Organ", "Percussive Organ", "Rock Organ", "Church Organ",
+	"Reed Organ", "Accordion", "Harmonica", "Tango Accordion",
+	// Guitar
+	"Acoustic Guitar (nylon)", "Acoustic Guitar (steel)", "Electric Guitar (jazz)", "Electric Guitar (clean)",
+	"Electric Guitar (muted)", "Overdriven Guitar", "Distortion Guitar", "Guitar Harmonics",
+	// Bass
+	"Acoustic Bass", "Electric Bass (finger)", "Electric Bass (pick)", "Fretless Bass",
+	"Slap Bass 1", "Slap Bass 2", "Synth Bass 1", "Synth Bass 2",
+	// Strings
+	"Violin", "Viola", "Cello", "Contrabass",
+	"Tremolo Strings", "Pizzicato Strings", "Orchestral Harp", "Timpani",
+	// Ensemble
+	"String Ensemble 1", "String Ensemble 2", "Synth Strings 1", "Synth Strings 2",
+	"Choir Aahs", "Voice Oohs", "Synth Voice", "Orchestra Hit",
+	// Brass
+	"Trumpet", "Trombone", "Tuba", "Muted Trumpet",
+	"French Horn", "Brass Section", "Synth Brass 1", "Synth Brass 2",
+	// Reed
+	"Soprano Sax", "Alto Sax", "Tenor Sax", "Baritone Sax",
+	"Oboe", "English Horn", "Bassoon", "Clarinet",
+	// Pipe
+	"Piccolo", "Flute", "Recorder", "Pan Flute",
+	"Blown Bottle", "Shakuhachi", "Whistle", "Ocarina",
+	// Synth Lead
+	"Lead 1 (square)", "Lead 2 (sawtooth)", "Lead 3 (calliope)", "Lead 4 (chiff)",
+	"Lead 5 (charang)", "Lead 6 (voice)", "Lead 7 (fifths)", "Lead 8 (bass + lead)",
+	// Synth Pad
+	"Pad 1 (new age)", "Pad 2 (warm)", "Pad 3 (polysynth)", "Pad 4 (choir)",
+	"Pad 5 (bowed)", "Pad 6 (metallic)", "Pad 7 (halo)", "Pad 8 (sweep)",
+	// Synth Effects
+	"FX 1 (rain)", "FX 2 (soundtrack)", "FX 3 (crystal)", "FX 4 (atmosphere)",
+	"FX 5 (brightness)", "FX 6 (goblins)", "FX 7 (echoes)", "FX 8 (sci-fi)",
+	// Ethnic
+	"Sitar", "Banjo", "Shamisen", "Koto",
+	"Kalimba", "Bagpipe", "Fiddle", "Shanai",
+	// Percussive
+	"Tinkle Bell", "Agogo", "Steel Drums", "Woodblock",
+	"Taiko Drum", "Melodic Tom", "Synth Drum", "Reverse Cymbal",
+	// Sound Effects
+	"Guitar Fret Noise", "Breath Noise", "Seashore", "Bird Tweet",
+	"Telephone Ring", "Helicopter", "Applause", "Gunshot",
+}
+
+// gmInstrumentName returns the General MIDI name of a program number
+func gmInstrumentName(program int) string {
+	if program < 0 || program >= len(gmInstrumentNames) {
+		return "Unknown"
+	}
+
+	return gmInstrumentNames[program]
+}
+
+// percussionChannel is the channel reserved for drums in General MIDI (channel 10 counting from 1)
+const percussionChannel = 9
+
+// Drum kit names of the percussion channel's programs (GS), programs between kits use the kit below them
+var gmDrumKitNames = []struct {
+	program int
+	name    string
+}{
+	{0, "Standard Drum Kit"},
+	{8, "Room Drum Kit"},
+	{16, "Power Drum Kit"},
+	{24, "Electronic Drum Kit"},
+	{25, "TR-808 Drum Kit"},
+	{32, "Jazz Drum Kit"},
+	{40, "Brush Drum Kit"},
+	{48, "Orchestra Drum Kit"},
+	{56, "SFX Drum Kit"},
+}
+
+// gmDrumKitName returns the name of the drum kit of a program on the percussion channel
+func gmDrumKitName(program int) string {
+	name := gmDrumKitNames[0].name
+	for _, kit := range gmDrumKitNames {
+		if kit.program <= program {
+			name = kit.name
+		}
+	}
+
+	return name
+}
+
+// instrumentName returns the name of a channel's program, program is -1 when the channel has no program change.
+// The percussion channel always plays a drum kit, defaulting to the standard kit.
+func instrumentName(channel int, program int) string {
+	if channel == percussionChannel {
+		return gmDrumKitName(program)
+	}
+	if program < 0 {
+		return "no program"
+	}
+
+	return gmInstrumentName(program)
+}
+
+// General MIDI percussion key map, the drum sound of each note on the percussion channel starting from note 35
+var gmPercussionNames = []string{
+	"Acoustic Bass Drum", "Bass Drum 1", "Side Stick", "Acoustic Snare",
+	"Hand Clap", "Electric Snare", "Low Floor Tom", "Closed Hi-Hat",
+	"High Floor Tom", "Pedal Hi-Hat", "Low Tom", "Open Hi-Hat",
+	"Low-Mid Tom", "Hi-Mid Tom", "Crash Cymbal 1", "High Tom",
+	"Ride Cymbal 1", "Chinese Cymbal", "Ride Bell", "Tambourine",
+	"Splash Cymbal", "Cowbell", "Crash Cymbal 2", "Vibraslap",
+	"Ride Cymbal 2", "Hi Bongo", "Low Bongo", "Mute Hi Conga",
+	"Open Hi Conga", "Low Conga", "High Timbale", "Low Timbale",
+	"High Agogo", "Low Agogo", "Cabasa", "Maracas",
+	"Short Whistle", "Long Whistle", "Short Guiro", "Long Guiro",
+	"Claves", "Hi Wood Block", "Low Wood Block", "Mute Cuica",
+	"Open Cuica", "Mute Triangle", "Open Triangle",
+}
+
+// firstPercussionNote is the note of the first drum sound in gmPercussionNames
+const firstPercussionNote = 35
+
+// noteNumberToPercussion returns the General MIDI drum sound of a note on the percussion channel,
+// falling back to the pitch name for notes outside the key map
+func noteNumberToPercussion(noteNumber byte) string {
+	i := int(noteNumber) - firstPercussionNote
+	if i < 0 || i >= len(gmPercussionNames) {
+		return noteNumberToString(noteNumber)
+	}
+
+	return gmPercussionNames[i]
+}
@@ -0,0 +1,90 @@
+package midivis
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+)
+
+// updateHUD toggles the HUD with H
+func (g *Game) updateHUD() {
+	if inpututil.IsKeyJustPressed(ebiten.KeyH) {
+		g.hud = !g.hud
+	}
+}
+
+// drawHUD draws the transport readout in the bottom left corner, above the waveform when it's drawn
+func (g *Game) drawHUD(screen *ebiten.Image) {
+	if !g.hud {
+		return
+	}
+
+	measure, beat, tick := g.measureBeatTick(g.elapsedDeltaTime)
+	seconds := g.tempoMap.tickToSeconds(g.elapsedDeltaTime)
+	position := time.Duration(seconds * float64(time.Second))
+
+	activeNotes := 0
+	g.forNotesBetween(g.elapsedDeltaTime, g.elapsedDeltaTime, func(n Note) {
+		if n.on <= g.elapsedDeltaTime && g.elapsedDeltaTime <= n.off {
+			activeNotes++
+		}
+	})
+
+	lines := []string{
+		// 1 based like a DAW's transport
+		fmt.Sprintf("position: %d:%d:%03d", measure+1, beat+1, tick),
+		fmt.Sprintf("tempo: %.1f bpm", TempoChange{microSecondsPerQuarterNote: g.tempoMap.tempoAt(g.elapsedDeltaTime)}.BPM()),
+		fmt.Sprintf("time: %d:%02d.%03d", int(position.Minutes()), int(position.Seconds())%60, position.Milliseconds()%1000),
+		fmt.Sprintf("active notes: %d", activeNotes),
+	}
+
+	// the debug font is 16 pixels per line at device pixels
+	const lineHeight, padding = 16, 8
+	bottom := float32(g.height)
+	if g.waveform != nil {
+		bottom -= waveformHeight
+	}
+	y := int(bottom*g.scale) - padding - len(lines)*lineHeight
+	for i, line := range lines {
+		ebitenutil.DebugPrintAt(screen, line, padding, y+i*lineHeight)
+	}
+}
+
+// measureBeatTick returns the measure, the beat within the measure and the tick within the beat of a tick,
+// counted from 0 using the time signature changes of the tracks. Before the first change the time signature is 4/4
+func (g *Game) measureBeatTick(tick int) (int, int, int) {
+	measure, segmentStart := 0, 0
+	numerator, denominator := 4, 4
+	for _, change := range g.timeSignatures {
+		if change.tick > tick {
+			break
+		}
+		// a change in the middle of a measure starts a new measure
+		ticksPerMeasure := max(numerator*g.ppqn*4/denominator, 1)
+		measure += (change.tick - segmentStart + ticksPerMeasure - 1) / ticksPerMeasure
+		segmentStart = change.tick
+		numerator, denominator = change.numerator, change.denominator
+	}
+
+	ticksPerBeat := max(g.ppqn*4/denominator, 1)
+	ticksPerMeasure := max(numerator*ticksPerBeat, 1)
+	inSegment := tick - segmentStart
+	inMeasure := inSegment % ticksPerMeasure
+	return measure + inSegment/ticksPerMeasure, inMeasure / ticksPerBeat, inMeasure % ticksPerBeat
+}
+
+// trackTimeSignatures merges the time signature changes of the tracks in tick order
+func trackTimeSignatures(tracks []*Track) []TimeSignatureChange {
+	changes := []TimeSignatureChange{}
+	for _, t := range tracks {
+		changes = append(changes, t.timeSignatureChanges...)
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].tick < changes[j].tick
+	})
+	return changes
+}
@@ -0,0 +1,37 @@
+package midivis
+
+import (
+	"fmt"
+	"io"
+)
+
+// WriteList writes the tempo and time signature changes of every track as ordered lists
+func (v *Visualizer) WriteList(w io.Writer) error {
+	for _, t := range v.tracks {
+		if _, err := fmt.Fprintf(w, "%s (ppqn %d, %d notes)\n", t.name, t.ppqn, len(t.notes)); err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintln(w, "  tempo changes:"); err != nil {
+			return err
+		}
+		for i, change := range t.tempoChanges {
+			_, err := fmt.Fprintf(w, "    %d. tick %d: %d us per quarter note (%.2f bpm)\n", i+1, change.tick, change.microSecondsPerQuarterNote, change.BPM())
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err := fmt.Fprintln(w, "  time signature changes:"); err != nil {
+			return err
+		}
+		for i, change := range t.timeSignatureChanges {
+			_, err := fmt.Fprintf(w, "    %d. tick %d: %d/%d\n", i+1, change.tick, change.numerator, change.denominator)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package midivis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"sort"
+)
+
+// TypeMappingFileName is the name of the optional mapping file in a midi directory
+const TypeMappingFileName = "mapping.json"
+
+// TypeMapping maps midi file names to note types, so a song's files can get their types without recompiling.
+// It's checked after the config's types and before the built-in file names
+type TypeMapping struct {
+	// names maps exact file names to note types
+	names map[string]int
+	// patterns are the regular expressions of the mapping, tried in alphabetical order
+	patterns []typeMappingPattern
+}
+
+type typeMappingPattern struct {
+	re       *regexp.Regexp
+	noteType int
+}
+
+// LoadTypeMapping reads a json object mapping file names or regular expressions to note type names,
+// e.g. {"kick.mid": "radialgradient", "^bridge.*": "rect"}, from fsys or from the OS's file system when fsys is nil.
+// Exact file names take precedence, then the regular expressions are tried in alphabetical order
+func LoadTypeMapping(fsys fs.FS, fileName string) (*TypeMapping, error) {
+	f, err := openFile(fsys, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dat, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]string{}
+	if err := json.Unmarshal(dat, &entries); err != nil {
+		return nil, fmt.Errorf("parsing mapping %s: %w", fileName, err)
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	mapping := &TypeMapping{names: map[string]int{}}
+	for _, key := range keys {
+		noteType, err := parseNoteType(entries[key])
+		if err != nil {
+			return nil, fmt.Errorf("mapping %s, %s: %w", fileName, key, err)
+		}
+		mapping.names[key] = noteType
+
+		re, err := regexp.Compile("^(?:" + key + ")$")
+		if err != nil {
+			// not every file name is a valid regular expression, those only match exactly
+			continue
+		}
+		mapping.patterns = append(mapping.patterns, typeMappingPattern{re: re, noteType: noteType})
+	}
+
+	return mapping, nil
+}
+
+// noteType returns the note type mapped to the file name, a nil mapping maps nothing
+func (m *TypeMapping) noteType(fileName string) (int, bool) {
+	if m == nil {
+		return 0, false
+	}
+
+	if noteType, ok := m.names[fileName]; ok {
+		return noteType, true
+	}
+	for _, pattern := range m.patterns {
+		if pattern.re.MatchString(fileName) {
+			return pattern.noteType, true
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,1086 @@
+package midivis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultMicroSecondsPerQuarterNote is the tempo of tracks without a Set Tempo event, 160 bpm
+const defaultMicroSecondsPerQuarterNote = 375000
+
+type MidiNoteType byte
+
+const (
+	NoteOff       MidiNoteType = 0x8
+	NoteOn        MidiNoteType = 0x9
+	ControlChange MidiNoteType = 0xB
+	ProgramChange MidiNoteType = 0xC
+)
+
+// Controller numbers of control change events
+const (
+	ControllerPan = 10
+)
+
+// centerPan is the pan value of a centered channel, pan ranges from 0 (hard left) to 127 (hard right)
+const centerPan = 64
+
+type MidiNote struct {
+	// tick is the absolute time of the event in the track, counting the delta times of every event before it
+	tick      int
+	eventType MidiNoteType
+	channel   byte
+	note      byte
+	velocity  byte
+	// controller and value are only set for control change events, value is also the program of program change events
+	controller byte
+	value      byte
+}
+
+type MidiTrack struct {
+	notes []MidiNote
+	// PPQN is the number of ticks per quarter note
+	// It is pulled from midi header (division)
+	ppqn uint16
+	// name is from the track name meta event, empty if the track has none
+	name string
+	// sequenceNumber is from the sequence number meta event, -1 if the track has none
+	sequenceNumber int
+	// warnings are the problems found while parsing the track, kept in the converted track's warnings
+	warnings []string
+	// tempoChanges and timeSignatureChanges are in tick order
+	tempoChanges         []TempoChange
+	timeSignatureChanges []TimeSignatureChange
+}
+
+// TempoChange is a set tempo meta event
+type TempoChange struct {
+	tick                       int
+	microSecondsPerQuarterNote int
+}
+
+// BPM returns the tempo in quarter notes per minute
+func (c TempoChange) BPM() float64 {
+	return 60000000 / float64(c.microSecondsPerQuarterNote)
+}
+
+// TimeSignatureChange is a time signature meta event
+type TimeSignatureChange struct {
+	tick        int
+	numerator   int
+	denominator int
+}
+
+type Note struct {
+	on      int
+	off     int
+	num     int
+	str     string
+	vel     int
+	channel int
+	// pan is the pan of the note's channel at note on
+	pan int
+	// program is the program of the note's channel at note on, -1 if there was no program change
+	program int
+	// overlaps is set when another note of the same pitch and channel turned on before this one turned off,
+	// which is usually a transcription error
+	overlaps bool
+	// accent is set when the velocity is well above the track's recent average
+	accent bool
+	// lane is the index of the note's track lane in Game.lanes when Lanes is set
+	lane int
+}
+
+// Num returns the midi note number
+func (n Note) Num() int {
+	return n.num
+}
+
+// Name returns the pitch name of the note, or its General MIDI drum sound on the percussion channel
+func (n Note) Name() string {
+	return n.str
+}
+
+// Velocity returns the note on velocity
+func (n Note) Velocity() int {
+	return n.vel
+}
+
+// Channel returns the midi channel counting from 0
+func (n Note) Channel() int {
+	return n.channel
+}
+
+// On returns the tick of the note on
+func (n Note) On() int {
+	return n.on
+}
+
+// Off returns the tick of the note off
+func (n Note) Off() int {
+	return n.off
+}
+
+// noteKey identifies the notes that can be on at once, notes with the same key overlap
+type noteKey struct {
+	channel byte
+	note    byte
+}
+
+type Track struct {
+	name  string
+	ppqn  uint16
+	bpm   int
+	notes []Note
+	// programChanges are in tick order
+	programChanges []ProgramChangeEvent
+	// tempoChanges and timeSignatureChanges are in tick order
+	tempoChanges         []TempoChange
+	timeSignatureChanges []TimeSignatureChange
+	// warnings are the problems found while converting the track
+	warnings []string
+	// compare is set for tracks loaded with LoadCompareFile, drawn faintly over the other tracks
+	compare bool
+}
+
+// ProgramChangeEvent is a change of the instrument of a channel
+type ProgramChangeEvent struct {
+	tick    int
+	channel int
+	program int
+}
+
+// programAt returns the program of the channel at the tick, -1 if the channel has no program yet
+func (t *Track) programAt(channel int, tick int) int {
+	program := -1
+	for _, change := range t.programChanges {
+		if change.tick > tick {
+			break
+		}
+		if change.channel == channel {
+			program = change.program
+		}
+	}
+
+	return program
+}
+
+// InstrumentAt returns the General MIDI instrument name of the channel at the tick
+func (t *Track) InstrumentAt(channel int, tick int) string {
+	return instrumentName(channel, t.programAt(channel, tick))
+}
+
+// warn logs a problem with the track and keeps it in the track's warnings
+func (t *Track) warn(logger *slog.Logger, msg string, args ...any) {
+	logger.Warn(msg, append([]any{"trackName", t.name}, args...)...)
+
+	var warning strings.Builder
+	warning.WriteString(msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&warning, " %v=%v", args[i], args[i+1])
+	}
+	t.warnings = append(t.warnings, warning.String())
+}
+
+// Name returns the name of the track, used to match it against the config's file patterns
+func (t *Track) Name() string {
+	return t.name
+}
+
+// Names of the notes of an octave, starting from C
+var noteNames = []string{
+	"C",
+	"C#",
+	"D",
+	"D#",
+	"E",
+	"F",
+	"F#",
+	"G",
+	"G#",
+	"A",
+	"A#",
+	"B",
+}
+
+func noteNumberToString(noteNumber byte) string {
+	octave := int(noteNumber / 12)
+	note := int(noteNumber % 12)
+	return fmt.Sprintf("%s%d", noteNames[note], octave)
+}
+
+// ParseNote parses a midi note number (e.g. "60") or a note name in the same format notes are displayed in (e.g. "C5")
+func ParseNote(s string) (int, error) {
+	num, err := strconv.Atoi(s)
+	if err != nil {
+		num = -1
+		// check sharps first so "C#" isn't read as "C"
+		for i := len(noteNames) - 1; i >= 0; i-- {
+			name, octave, ok := strings.Cut(strings.ToUpper(s), noteNames[i])
+			if !ok || name != "" {
+				continue
+			}
+			octaveInt, err := strconv.Atoi(octave)
+			if err != nil {
+				return 0, fmt.Errorf("invalid octave in note %q", s)
+			}
+			num = octaveInt*12 + i
+			break
+		}
+		if num == -1 {
+			return 0, fmt.Errorf("invalid note %q, expected a number or a name like C5", s)
+		}
+	}
+
+	if num < 0 || num > 127 {
+		return 0, fmt.Errorf("note %q is out of range, must be between 0 and 127", s)
+	}
+
+	return num, nil
+}
+
+func readVariableLengthValue2(dat io.Reader) (result int, err error) {
+	result = 0
+	for {
+		b := make([]byte, 1)
+		_, err = io.ReadFull(dat, b)
+		if err != nil {
+			return 0, err
+		}
+		result = (result << 7) | int(b[0]&0x7F)
+		if b[0]&0x80 == 0 {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+func NewMidiTrack() *MidiTrack {
+
+	return &MidiTrack{
+		notes:          []MidiNote{},
+		ppqn:           0,
+		sequenceNumber: -1,
+	}
+}
+
+func NewTrack(name string, ppqn uint16) *Track {
+
+	return &Track{
+		name:  name,
+		notes: []Note{},
+		ppqn:  ppqn,
+	}
+}
+
+// ParseMIDI parses midi data into tracks, named by the track name meta event of each track
+func ParseMIDI(r io.Reader) (tracks []*Track, err error) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	// malformed data the parser doesn't check for can still panic, it shouldn't take the caller down
+	defer recoverParseError(&err)
+
+	midiTracks, err := parseMidi(logger, r)
+	if err != nil {
+		return nil, fmt.Errorf("parsing midi: %w", err)
+	}
+
+	for _, midiTrack := range midiTracks {
+		tracks = append(tracks, midiTrack.ToTrack(logger, midiTrack.name, Options{}))
+	}
+	return tracks, nil
+}
+
+// sortBySequenceNumber orders the tracks of a file by their sequence number meta events.
+// Tracks without one are ordered by their position in the file, like the sequence number's default
+func sortBySequenceNumber(midiTracks []*MidiTrack) {
+	order := make(map[*MidiTrack]int, len(midiTracks))
+	for i, midiTrack := range midiTracks {
+		order[midiTrack] = i
+		if midiTrack.sequenceNumber >= 0 {
+			order[midiTrack] = midiTrack.sequenceNumber
+		}
+	}
+
+	sort.SliceStable(midiTracks, func(i, j int) bool {
+		return order[midiTracks[i]] < order[midiTracks[j]]
+	})
+}
+
+// recoverParseError turns a panic while parsing into an error
+func recoverParseError(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("parsing midi: %v", r)
+	}
+}
+
+// parseMidiFile opens and parses a midi file of fsys, or of the OS's file system when fsys is nil.
+// Files ending in .gz are decompressed while parsing
+func parseMidiFile(logger *slog.Logger, fsys fs.FS, fileName string) (midiTracks []*MidiTrack, err error) {
+	f, err := openFile(fsys, fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(fileName, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	defer func() {
+		recoverParseError(&err)
+		if err != nil {
+			midiTracks = nil
+			err = fmt.Errorf("%s: %w", fileName, err)
+			logger.Error("Failed to parse midi file", "fileName", fileName, "err", err)
+		}
+	}()
+
+	midiTracks, err = parseMidi(logger, r)
+	if err != nil {
+		return nil, fmt.Errorf("parsing midi: %w", err)
+	}
+	return midiTracks, nil
+}
+
+// parseMidi parses the header and every track chunk of midi data, ordered by their sequence numbers.
+// Format 0 files have a single track, format 1 files have one track per chunk sharing the first track's tempo map.
+// dat can come from anywhere, like an embedded asset, an HTTP body or a bytes.Buffer, parseMidiFile only opens the file
+func parseMidi(logger *slog.Logger, dat io.Reader) ([]*MidiTrack, error) {
+	// Reference: https://midimusic.github.io/tech/midispec.html
+	var err error
+
+	// first 4 bytes (32 bits) are the header type in ascii
+	headerBytes := make([]byte, 4)
+	_, err = io.ReadFull(dat, headerBytes)
+	if err != nil {
+		return nil, fmt.Errorf("reading header type: %w", err)
+	}
+	logger.Info("Header Type", "headerType", string(headerBytes))
+
+	// length is the next 4 bytes (32 bits) in big endian
+	lengthBytes := make([]byte, 4)
+	_, err = io.ReadFull(dat, lengthBytes)
+	if err != nil {
+		return nil, fmt.Errorf("reading header length: %w", err)
+	}
+	lengthInt := binary.BigEndian.Uint32(lengthBytes)
+	logger.Info("Length", "length", lengthInt)
+
+	// -- Data Section --
+	// format is the next 2 bytes (16 bits) in big endian
+	formatBytes := make([]byte, 2)
+	_, err = io.ReadFull(dat, formatBytes)
+	if err != nil {
+		return nil, fmt.Errorf("reading format: %w", err)
+	}
+	formatInt := binary.BigEndian.Uint16(formatBytes)
+	logger.Info("Format", "format", formatInt)
+	if formatInt > 1 {
+		return nil, fmt.Errorf("format %d not supported, only formats 0 and 1 are", formatInt)
+	}
+
+	// ntracks is the next 2 bytes (16 bits) in big endian
+	nTracksBytes := make([]byte, 2)
+	_, err = io.ReadFull(dat, nTracksBytes)
+	if err != nil {
+		return nil, fmt.Errorf("reading ntracks: %w", err)
+	}
+	nTracksInt := binary.BigEndian.Uint16(nTracksBytes)
+	logger.Info("NTracks", "nTracks", nTracksInt)
+
+	// division is the next 2 bytes (16 bits) in big endian
+	// if the first bit is 0, the remaining 15 bits represent the number of ticks quarter note
+	//   For instance, if division is 96, then a time interval of an eighth-note between two events in the file would be 48
+	// if the first bit is 1, the remaining 15 bits represent the number of ticks per frame
+	divisionTypeBytes := make([]byte, 2)
+	_, err = io.ReadFull(dat, divisionTypeBytes)
+	if err != nil {
+		return nil, fmt.Errorf("reading division: %w", err)
+	}
+	logger.Info("Division Type", "divisionType", divisionTypeBytes[0])
+
+	var ppqn uint16
+	if divisionTypeBytes[0]&0x80 == 0 {
+		ppqn = binary.BigEndian.Uint16(divisionTypeBytes)
+		logger.Info("Division (Ticks per Quarter Note)", "ppqn", ppqn)
+		// every tick conversion divides by the ppqn
+		if ppqn == 0 {
+			return nil, fmt.Errorf("invalid division 0, it must be at least 1 tick per quarter note")
+		}
+	} else {
+		return nil, fmt.Errorf("division type not supported, only ticks per quarter note are")
+	}
+
+	// the header length is 6 for the format, ntracks and division above, but may be longer
+	// skip any extra bytes so the track chunk is read from the right place
+	const standardHeaderLength = 6
+	if lengthInt > standardHeaderLength {
+		_, err = io.CopyN(io.Discard, dat, int64(lengthInt-standardHeaderLength))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// -- Track Section --
+	// The format for Track Chunks (described below) is exactly the same for all three formats (0, 1, and 2: see "Header Chunk" above) of MIDI Files.
+	// <Track Chunk> = <chunk type><length><MTrk event>+
+	midiTracks := []*MidiTrack{}
+	for len(midiTracks) < int(nTracksInt) {
+		// chunk type is the next 4 bytes (32 bits) in ascii
+		chunkTypeBytes := make([]byte, 4)
+		_, err = io.ReadFull(dat, chunkTypeBytes)
+		if err == io.EOF && len(midiTracks) > 0 {
+			logger.Warn("Missing track chunks, stopping at the end of the file", "nTracks", nTracksInt, "tracksRead", len(midiTracks))
+			last := midiTracks[len(midiTracks)-1]
+			last.warnings = append(last.warnings, fmt.Sprintf("Missing track chunks, found %d of %d", len(midiTracks), nTracksInt))
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading track header: %w", err)
+		}
+		logger.Info("Track Header", "chunkType", string(chunkTypeBytes))
+
+		// chunk length is the next 4 bytes (32 bits) in big endian
+		chunkLengthBytes := make([]byte, 4)
+		_, err = io.ReadFull(dat, chunkLengthBytes)
+		if err != nil {
+			return nil, fmt.Errorf("reading track length: %w", err)
+		}
+		chunkLengthInt := binary.BigEndian.Uint32(chunkLengthBytes)
+		logger.Info("Track Length", "length", chunkLengthInt)
+
+		// limit reads to the chunk so events can't run past the end of the track
+		chunkReader := &io.LimitedReader{R: dat, N: int64(chunkLengthInt)}
+		// chunks of other types are allowed and should be ignored
+		if string(chunkTypeBytes) == "MTrk" {
+			midiTrack, err := parseTrackChunk(logger, chunkReader)
+			if err != nil {
+				return nil, fmt.Errorf("track %d: %w", len(midiTracks), err)
+			}
+			midiTrack.ppqn = ppqn
+			midiTracks = append(midiTracks, midiTrack)
+		} else {
+			logger.Warn("Skipping unknown chunk", "chunkType", string(chunkTypeBytes))
+		}
+
+		// skip whatever is left after the End of Track so the next chunk is read from the right place
+		if chunkReader.N > 0 {
+			_, err = io.Copy(io.Discard, chunkReader)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// in format 1 the first track holds the tempo map for all of the tracks
+	if formatInt == 1 && len(midiTracks) > 0 {
+		conductor := midiTracks[0]
+		for _, midiTrack := range midiTracks[1:] {
+			if len(midiTrack.tempoChanges) == 0 {
+				midiTrack.tempoChanges = conductor.tempoChanges
+			}
+			if len(midiTrack.timeSignatureChanges) == 0 {
+				midiTrack.timeSignatureChanges = conductor.timeSignatureChanges
+			}
+		}
+	}
+
+	sortBySequenceNumber(midiTracks)
+	return midiTracks, nil
+}
+
+// parseTrackChunk parses the events of a track chunk, trackReader is limited to the chunk's length
+func parseTrackChunk(logger *slog.Logger, trackReader *io.LimitedReader) (*MidiTrack, error) {
+	var err error
+	midiTrack := NewMidiTrack()
+	var dat io.Reader = trackReader
+
+	// read track data in the format:
+	// <MTrk event> = <delta-time><event>
+	// <delta-time> is stored as a variable-length quantity.
+	// It represents the amount of time before the following event.
+	// 	If the first event in a track occurs at the very beginning of a track, or if two events occur simultaneously, a delta-time of zero is used. Delta-times are always present.
+	// (Not storing delta-times of 0 requires at least two bytes for any other value, and most delta-times aren't zero.)
+	// Delta-time is in some fraction of a beat (or a second, for recording a track with SMPTE times), as specified in the header chunk.
+	// <event> = <MIDI event> | <sysex event> | <meta-event>
+	// Print only note on and note offf midi events and their data as well as delta time events
+	// eventsRemaining := 6
+	done := false
+	// a sysex message can be divided into an 0xF0 packet followed by 0xF7 continuation packets,
+	// inSysex is set until the packet ending the message with 0xF7 is read
+	inSysex := false
+	sysexMessage := []byte{}
+	// runningStatus is the status byte of the last MIDI event, reused by events that leave theirs out
+	runningStatus := byte(0)
+	// tick is the absolute time of the current event, stored with the events so the notes and the tempo map
+	// share a timeline even across the events that aren't stored
+	tick := 0
+	for !done {
+		// eventsRemaining--
+		logger.Debug("------- EVENT -------")
+
+		// some exports are missing the End of Track event, stop at the end of the track chunk or file instead
+		if trackReader.N == 0 {
+			logger.Warn("Missing End of Track, stopping at the end of the track chunk")
+			midiTrack.warnings = append(midiTrack.warnings, "Missing End of Track")
+			break
+		}
+		firstDeltaTimeByte := make([]byte, 1)
+		_, err = io.ReadFull(dat, firstDeltaTimeByte)
+		if err == io.EOF {
+			logger.Warn("Missing End of Track, stopping at the end of the file", "bytesMissing", trackReader.N)
+			midiTrack.warnings = append(midiTrack.warnings, fmt.Sprintf("Missing End of Track, file ends %d bytes early", trackReader.N))
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		deltaTime, err := readVariableLengthValue2(io.MultiReader(bytes.NewReader(firstDeltaTimeByte), dat))
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug("Delta Time", "deltaTime", deltaTime)
+		tick += deltaTime
+
+		// <event> = <MIDI event> | <sysex event> | <meta-event>
+		eventFirstByte := make([]byte, 1)
+		_, err = io.ReadFull(dat, eventFirstByte)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug("Event first byte", "byte", eventFirstByte[0])
+
+		if eventFirstByte[0] == 0xFF {
+			// <meta-event> = 0xFF<type><length><data>
+			// meta and sysex events cancel the running status
+			runningStatus = 0
+			metaEventType := make([]byte, 1)
+			_, err = io.ReadFull(dat, metaEventType)
+			if err != nil {
+				return nil, err
+			}
+
+			metaEventLength, err := readVariableLengthValue2(dat)
+			if err != nil {
+				return nil, err
+			}
+			// check before allocating the data, a corrupt length could ask for gigabytes
+			if int64(metaEventLength) > trackReader.N {
+				return nil, fmt.Errorf("invalid meta event length %d, runs past the end of the track", metaEventLength)
+			}
+
+			switch metaEventType[0] {
+			case 0x00:
+				{
+					logger.Debug("Meta Event Type (Sequence Number)", "type", metaEventType[0])
+					// a length of 0 means the sequence number is the track's position in the file
+					if metaEventLength != 0 && metaEventLength != 2 {
+						return nil, fmt.Errorf("invalid sequence number length %d", metaEventLength)
+					}
+					if metaEventLength == 2 {
+						sequenceNumber := make([]byte, 2)
+						_, err = io.ReadFull(dat, sequenceNumber)
+						if err != nil {
+							return nil, err
+						}
+						midiTrack.sequenceNumber = int(binary.BigEndian.Uint16(sequenceNumber))
+						logger.Debug("  Sequence Number", "sequenceNumber", midiTrack.sequenceNumber)
+					}
+					break
+				}
+			case 0x03:
+				{
+					trackName := make([]byte, metaEventLength)
+					_, err = io.ReadFull(dat, trackName)
+					if err != nil {
+						return nil, err
+					}
+					logger.Debug("Meta Event Type (Track Name)", "type", metaEventType[0])
+					logger.Debug("  Track Name", "trackName", string(trackName))
+					midiTrack.name = string(trackName)
+
+					break
+				}
+			case 0x2F:
+				{
+					logger.Debug("Meta Event Type (End of Track)", "type", metaEventType[0])
+					if metaEventLength != 0 {
+						return nil, fmt.Errorf("invalid end of track length %d", metaEventLength)
+					}
+					// consume the data even though we don't use it now
+					// metaEventData := make([]byte, metaEventLength)
+					// _, err = io.ReadFull(dat, metaEventData)
+					// check(err)
+					done = true
+					break
+				}
+			case 0x58:
+				{
+					logger.Debug("Meta Event Type (Time Signature)", "type", metaEventType[0])
+					if metaEventLength != 4 {
+						return nil, fmt.Errorf("invalid time signature length %d", metaEventLength)
+					}
+
+					numerator := make([]byte, 1)
+					_, err = io.ReadFull(dat, numerator)
+					if err != nil {
+						return nil, err
+					}
+					denominator := make([]byte, 1)
+					_, err = io.ReadFull(dat, denominator)
+					if err != nil {
+						return nil, err
+					}
+					cc := make([]byte, 1)
+					_, err = io.ReadFull(dat, cc)
+					if err != nil {
+						return nil, err
+					}
+					bb := make([]byte, 1)
+					_, err = io.ReadFull(dat, bb)
+					if err != nil {
+						return nil, err
+					}
+					logger.Debug("  Numerator", "numerator", numerator[0])
+					logger.Debug("  Denominator", "denominator", denominator[0])
+					// the denominator is stored as a power of 2, larger powers than 64th notes are corrupt data
+					// and would overflow the shift
+					if denominator[0] > maxDenominatorPower {
+						return nil, fmt.Errorf("invalid time signature denominator 2^%d, must be at most 2^%d", denominator[0], maxDenominatorPower)
+					}
+					midiTrack.timeSignatureChanges = append(midiTrack.timeSignatureChanges, TimeSignatureChange{
+						tick:        tick,
+						numerator:   int(numerator[0]),
+						denominator: 1 << denominator[0],
+					})
+					break
+				}
+			case 0x51:
+				{
+					logger.Debug("Meta Event Type (Set Tempo)", "type", metaEventType[0])
+					if metaEventLength != 3 {
+						return nil, fmt.Errorf("invalid set tempo length %d", metaEventLength)
+					}
+
+					mpqn := make([]byte, 3)
+					_, err = io.ReadFull(dat, mpqn)
+					if err != nil {
+						return nil, err
+					}
+					microSecondsPerQuarterNoteInt := uint32(mpqn[0])<<16 | uint32(mpqn[1])<<8 | uint32(mpqn[2])
+					logger.Info("  Microseconds Per Quarter Note", "microSecondsPerQuarterNote", microSecondsPerQuarterNoteInt)
+					if microSecondsPerQuarterNoteInt == 0 {
+						return nil, fmt.Errorf("invalid set tempo, tempo is 0")
+					}
+					midiTrack.tempoChanges = append(midiTrack.tempoChanges, TempoChange{
+						tick:                       tick,
+						microSecondsPerQuarterNote: int(microSecondsPerQuarterNoteInt),
+					})
+					break
+				}
+			default:
+				logger.Debug("Meta Event Type", "type", metaEventType[0])
+				logger.Debug("Meta Event Length", "length", metaEventLength)
+
+				// consume the data even though we don't use it now
+				metaEventData := make([]byte, metaEventLength)
+				_, err = io.ReadFull(dat, metaEventData)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			// logger.Debug("Meta Event Data:", string(metaEventData))
+		} else if eventFirstByte[0] == 0xF0 || eventFirstByte[0] == 0xF7 {
+			// <sysex event> = 0xF0<length><data> or 0xF7<length><data>
+			runningStatus = 0
+			sysexEventLength, err := readVariableLengthValue2(dat)
+			if err != nil {
+				return nil, err
+			}
+			logger.Debug("Sysex Event Length", "length", sysexEventLength)
+			if int64(sysexEventLength) > trackReader.N {
+				return nil, fmt.Errorf("invalid sysex length %d, runs past the end of the track", sysexEventLength)
+			}
+			// consume the data even though we don't use it now
+			sysexEventData := make([]byte, sysexEventLength)
+			_, err = io.ReadFull(dat, sysexEventData)
+			if err != nil {
+				return nil, err
+			}
+
+			// an 0xF7 packet outside of a divided message is an escape of arbitrary bytes, not part of a sysex
+			if eventFirstByte[0] == 0xF0 || inSysex {
+				sysexMessage = append(sysexMessage, sysexEventData...)
+				inSysex = sysexEventLength == 0 || sysexEventData[sysexEventLength-1] != 0xF7
+				if !inSysex {
+					logger.Debug("Sysex Message Length", "length", len(sysexMessage))
+					sysexMessage = sysexMessage[:0]
+				}
+			}
+		} else {
+			// <MIDI event> = <MIDI event type><channel><data>
+			// <MIDI event type> = <MIDI event type (4 bits)><MIDI channel (4 bits)>
+			// <MIDI event type> = 0x8 for note off, 0x9 for note on
+			// with running status the event leaves out its status byte when it's the same as the last event's,
+			// so the first byte is already a data byte
+			midiEventType := eventFirstByte[0]
+			var eventData io.Reader = dat
+			if midiEventType&0x80 == 0 {
+				if runningStatus == 0 {
+					return nil, fmt.Errorf("invalid midi event, data byte %#x without a running status", midiEventType)
+				}
+				midiEventType = runningStatus
+				eventData = io.MultiReader(bytes.NewReader(eventFirstByte), dat)
+			} else {
+				runningStatus = midiEventType
+			}
+			logger.Debug("RAW MIDI Event Type", "eventType", midiEventType)
+
+			midiChannel := midiEventType & 0x0F
+			midiEventType = midiEventType >> 4
+
+			switch midiEventType {
+			case 0x8:
+				{
+					logger.Debug("MIDI Event Type: Note Off")
+					note := make([]byte, 1)
+					_, err = io.ReadFull(eventData, note)
+					if err != nil {
+						return nil, err
+					}
+					velocity := make([]byte, 1)
+					_, err = io.ReadFull(eventData, velocity)
+					if err != nil {
+						return nil, err
+					}
+					logger.Debug("  Note", "note", note[0], "name", noteNumberToString(note[0]))
+					logger.Debug("  Velocity", "velocity", velocity[0])
+
+					midiTrack.notes = append(midiTrack.notes, MidiNote{
+						tick:      tick,
+						eventType: NoteOff,
+						channel:   midiChannel,
+						note:      note[0],
+						velocity:  velocity[0],
+					})
+					break
+				}
+			case 0x9:
+				{
+					logger.Debug("MIDI Event Type: Note On")
+					note := make([]byte, 1)
+					_, err = io.ReadFull(eventData, note)
+					if err != nil {
+						return nil, err
+					}
+					velocity := make([]byte, 1)
+					_, err = io.ReadFull(eventData, velocity)
+					if err != nil {
+						return nil, err
+					}
+					logger.Debug("  Note", "note", note[0], "name", noteNumberToString(note[0]))
+					logger.Debug("  Velocity", "velocity", velocity[0])
+
+					midiTrack.notes = append(midiTrack.notes, MidiNote{
+						tick:      tick,
+						eventType: NoteOn,
+						channel:   midiChannel,
+						note:      note[0],
+						velocity:  velocity[0],
+					})
+					break
+				}
+			case 0xB:
+				{
+					logger.Debug("MIDI Event Type: Control Change")
+					controller := make([]byte, 1)
+					_, err = io.ReadFull(eventData, controller)
+					if err != nil {
+						return nil, err
+					}
+					value := make([]byte, 1)
+					_, err = io.ReadFull(eventData, value)
+					if err != nil {
+						return nil, err
+					}
+					logger.Debug("  Controller", "controller", controller[0])
+					logger.Debug("  Value", "value", value[0])
+
+					midiTrack.notes = append(midiTrack.notes, MidiNote{
+						tick:       tick,
+						eventType:  ControlChange,
+						channel:    midiChannel,
+						controller: controller[0],
+						value:      value[0],
+					})
+					break
+				}
+			case 0xC:
+				{
+					logger.Debug("MIDI Event Type: Program Change")
+					program := make([]byte, 1)
+					_, err = io.ReadFull(eventData, program)
+					if err != nil {
+						return nil, err
+					}
+					logger.Debug("  Program", "program", program[0], "instrument", gmInstrumentName(int(program[0])))
+
+					midiTrack.notes = append(midiTrack.notes, MidiNote{
+						tick:      tick,
+						eventType: ProgramChange,
+						channel:   midiChannel,
+						value:     program[0],
+					})
+					break
+				}
+			case 0xA, 0xE:
+				{
+					// polyphonic key pressure and pitch bend, consume the data even though we don't use it now
+					data := make([]byte, 2)
+					_, err = io.ReadFull(eventData, data)
+					if err != nil {
+						return nil, err
+					}
+					break
+				}
+			case 0xD:
+				{
+					// channel pressure, consume the data even though we don't use it now
+					data := make([]byte, 1)
+					_, err = io.ReadFull(eventData, data)
+					if err != nil {
+						return nil, err
+					}
+					break
+				}
+			}
+		}
+	}
+
+	return midiTrack, nil
+}
+
+// maxDenominatorPower is the largest power of 2 accepted as a time signature denominator, 64th notes
+const maxDenominatorPower = 6
+
+// defaultMinNoteBeats is the minimum length in beats of notes when Options.MinNoteBeats isn't set
+const defaultMinNoteBeats = 1.0 / 64
+
+// lowPPQN is the ppqn below which animations get noticeably chunky
+const lowPPQN = 96
+
+// hasNotes reports whether the track has any note on events, unlike a format 1 file's tempo track
+func (midiTrack *MidiTrack) hasNotes() bool {
+	for _, note := range midiTrack.notes {
+		if note.eventType == NoteOn {
+			return true
+		}
+	}
+	return false
+}
+
+func (midiTrack *MidiTrack) ToTrack(logger *slog.Logger, fileName string, opts Options) *Track {
+	// upsampling multiplies the ticks of the notes and the ppqn by the same factor, keeping their relative timing
+	upsample := max(opts.Upsample, 1)
+	track := NewTrack(fileName, midiTrack.ppqn)
+	track.warnings = append(track.warnings, midiTrack.warnings...)
+	// the upsampled ppqn has to fit the 16 bits of the division, multiplied as ints so it can't wrap around
+	if maxUpsample := math.MaxUint16 / max(int(midiTrack.ppqn), 1); upsample > maxUpsample {
+		track.warn(logger, "Upsample too large for the ppqn, lowering it", "upsample", upsample, "ppqn", midiTrack.ppqn, "max", maxUpsample)
+		upsample = maxUpsample
+	}
+	track.ppqn = uint16(int(midiTrack.ppqn) * upsample)
+
+	if midiTrack.ppqn < lowPPQN {
+		track.warn(logger, "Low PPQN, timing may be coarse, try -upsample", "ppqn", midiTrack.ppqn)
+	}
+	noteOnMap := make(map[noteKey]Note)
+	// noteOnCounts is the number of note ons without a note off of each open note.
+	// Overlapping notes of the same pitch and channel merge into one note, from the first note on
+	// to the note off that balances the note ons, so no note is lost
+	noteOnCounts := make(map[noteKey]int)
+	overlaps := 0
+	// notes shorter than minNoteTicks are lengthened to it, zero length notes would only be on for a single tick
+	minNoteBeats := opts.MinNoteBeats
+	if minNoteBeats <= 0 {
+		minNoteBeats = defaultMinNoteBeats
+	}
+	minNoteTicks := max(int(minNoteBeats*float64(track.ppqn)), 1)
+	lengthened := 0
+	// current pan of each channel, channels without a pan event are centered
+	channelPan := make(map[byte]int)
+	// current program of each channel
+	channelProgram := make(map[byte]int)
+
+	for _, change := range midiTrack.tempoChanges {
+		change.tick *= upsample
+		track.tempoChanges = append(track.tempoChanges, change)
+	}
+	for _, change := range midiTrack.timeSignatureChanges {
+		change.tick *= upsample
+		track.timeSignatureChanges = append(track.timeSignatureChanges, change)
+	}
+	for _, midiNote := range midiTrack.notes {
+		tick := midiNote.tick * upsample
+
+		// a note on with a velocity of 0 is a note off, it has to balance the note on count rather than add to it
+		eventType := midiNote.eventType
+		if eventType == NoteOn && midiNote.velocity == 0 {
+			eventType = NoteOff
+		}
+
+		if eventType == ControlChange {
+			if midiNote.controller == ControllerPan {
+				channelPan[midiNote.channel] = int(midiNote.value)
+			}
+		} else if eventType == ProgramChange {
+			channelProgram[midiNote.channel] = int(midiNote.value)
+			track.programChanges = append(track.programChanges, ProgramChangeEvent{
+				tick:    tick,
+				channel: int(midiNote.channel),
+				program: int(midiNote.value),
+			})
+		} else if eventType == NoteOn {
+			pan, ok := channelPan[midiNote.channel]
+			if !ok {
+				pan = centerPan
+			}
+
+			program, ok := channelProgram[midiNote.channel]
+			if !ok {
+				program = -1
+			}
+
+			num := min(max(int(midiNote.note)+opts.Transpose, 0), 127)
+			// notes on the percussion channel are drum sounds rather than pitches
+			str := noteNumberToString(byte(num))
+			if midiNote.channel == percussionChannel {
+				str = noteNumberToPercussion(byte(num))
+			}
+
+			// a note on while the same pitch is still on extends the open note and tags it
+			key := noteKey{midiNote.channel, midiNote.note}
+			noteOnCounts[key]++
+			if overlapped, isOverlap := noteOnMap[key]; isOverlap {
+				overlapped.overlaps = true
+				noteOnMap[key] = overlapped
+				overlaps++
+				continue
+			}
+
+			noteOnMap[key] = Note{
+				on:      tick,
+				off:     -1,
+				num:     num,
+				str:     str,
+				vel:     int(midiNote.velocity),
+				channel: int(midiNote.channel),
+				pan:     pan,
+				program: program,
+			}
+		} else if eventType == NoteOff {
+			key := noteKey{midiNote.channel, midiNote.note}
+			if foundNote, ok := noteOnMap[key]; ok {
+				// the note stays open until every overlapping note on has its note off
+				noteOnCounts[key]--
+				if noteOnCounts[key] > 0 {
+					continue
+				}
+				delete(noteOnCounts, key)
+				delete(noteOnMap, key)
+				// a note off before its note on would draw with a negative width
+				if tick < foundNote.on {
+					track.warn(logger, "Dropping note with note off before note on", "note", foundNote.str, "on", foundNote.on, "off", tick)
+					continue
+				}
+				foundNote.off = tick
+				if foundNote.off-foundNote.on < minNoteTicks {
+					foundNote.off = foundNote.on + minNoteTicks
+					lengthened++
+				}
+				track.notes = append(track.notes, foundNote)
+			} else {
+				logger.Info("Note Off without Note On")
+			}
+		}
+	}
+
+	if overlaps > 0 {
+		track.warn(logger, "Overlapping notes of the same pitch and channel", "count", overlaps)
+	}
+	if len(noteOnMap) > 0 {
+		track.warn(logger, "Dropping notes without a note off", "count", len(noteOnMap))
+	}
+	if lengthened > 0 {
+		logger.Debug("Lengthened short notes", "count", lengthened, "minTicks", minNoteTicks)
+	}
+
+	if opts.AccentThreshold > 0 {
+		tagAccents(track.notes, opts.AccentThreshold)
+	}
+
+	return track
+}
+
+// accentSmoothing is the weight of each note in the running average velocity used to find accents
+const accentSmoothing = 0.2
+
+// tagAccents tags the notes whose velocity is more than threshold above the running average velocity of the notes before them
+func tagAccents(notes []Note, threshold int) {
+	// the notes are in note off order, the average follows them in note on order
+	byOn := make([]int, len(notes))
+	for i := range byOn {
+		byOn[i] = i
+	}
+	sort.SliceStable(byOn, func(i, j int) bool {
+		return notes[byOn[i]].on < notes[byOn[j]].on
+	})
+
+	average := -1.0
+	for _, i := range byOn {
+		vel := float64(notes[i].vel)
+		if average < 0 {
+			average = vel
+		}
+		notes[i].accent = vel-average > float64(threshold)
+		average += (vel - average) * accentSmoothing
+	}
+}
+
+func secondsToDeltaTime(elapsedTime float64, microSecondsPerQuarterNote int, ppqn int) int {
+	// Convert microseconds per quarter note to seconds per tick
+	secondsPerTick := float64(microSecondsPerQuarterNote) / (1000000.0 * float64(ppqn))
+
+	// Calculate delta time in ticks
+	deltaTime := elapsedTime / secondsPerTick
+
+	// Round to the nearest integer (since delta time must be an integer value in MIDI)
+	return int(math.Round(deltaTime))
+}
+
+func deltaTimeToSeconds(deltaTime int, microSecondsPerQuarterNote int, ppqn int) float64 {
+	// Convert microseconds per quarter note to seconds per tick
+	secondsPerTick := float64(microSecondsPerQuarterNote) / (1000000.0 * float64(ppqn))
+
+	// Calculate elapsed time in seconds
+	elapsedTime := float64(deltaTime) * secondsPerTick
+
+	return elapsedTime
+}
@@ -0,0 +1,427 @@
+package midivis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// vlq encodes a variable-length quantity
+func vlq(v int) []byte {
+	out := []byte{byte(v & 0x7F)}
+	for v >>= 7; v > 0; v >>= 7 {
+		out = append([]byte{byte(v&0x7F) | 0x80}, out...)
+	}
+	return out
+}
+
+func event(delta int, data ...byte) []byte {
+	return append(vlq(delta), data...)
+}
+
+func noteOnEvent(delta int, channel, note, velocity byte) []byte {
+	return event(delta, 0x90|channel, note, velocity)
+}
+
+func noteOffEvent(delta int, channel, note byte) []byte {
+	return event(delta, 0x80|channel, note, 0)
+}
+
+func tempoEvent(delta int, microSecondsPerQuarterNote int) []byte {
+	us := microSecondsPerQuarterNote
+	return event(delta, 0xFF, 0x51, 0x03, byte(us>>16), byte(us>>8), byte(us))
+}
+
+// trackChunk builds an MTrk chunk of the events followed by an End of Track
+func trackChunk(events ...[]byte) []byte {
+	data := bytes.Join(events, nil)
+	data = append(data, event(0, 0xFF, 0x2F, 0x00)...)
+
+	chunk := []byte("MTrk")
+	chunk = binary.BigEndian.AppendUint32(chunk, uint32(len(data)))
+	return append(chunk, data...)
+}
+
+// smf builds a standard midi file of the track chunks
+func smf(format int, ppqn int, tracks ...[]byte) []byte {
+	file := []byte("MThd")
+	file = binary.BigEndian.AppendUint32(file, 6)
+	file = binary.BigEndian.AppendUint16(file, uint16(format))
+	file = binary.BigEndian.AppendUint16(file, uint16(len(tracks)))
+	file = binary.BigEndian.AppendUint16(file, uint16(ppqn))
+	for _, track := range tracks {
+		file = append(file, track...)
+	}
+	return file
+}
+
+// testSMF is a small valid file with a tempo and a couple of notes
+func testSMF() []byte {
+	return smf(0, 96, trackChunk(
+		tempoEvent(0, 500000),
+		noteOnEvent(0, 0, 60, 100),
+		noteOffEvent(96, 0, 60),
+		noteOnEvent(0, 0, 64, 90),
+		noteOffEvent(48, 0, 64),
+	))
+}
+
+// parseTestMidi parses midi data into raw tracks, failing the test on errors
+func parseTestMidi(t *testing.T, dat []byte) []*MidiTrack {
+	t.Helper()
+	midiTracks, err := parseMidi(discardLogger(), bytes.NewReader(dat))
+	if err != nil {
+		t.Fatalf("parseMidi: %v", err)
+	}
+	return midiTracks
+}
+
+func TestParseMIDITruncated(t *testing.T) {
+	full := testSMF()
+	// every prefix either fails to parse or parses up to the cut with a warning, none may panic
+	for n := 0; n < len(full); n++ {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%d of %d bytes: panic %v", n, len(full), r)
+				}
+			}()
+
+			tracks, err := ParseMIDI(bytes.NewReader(full[:n]))
+			if err != nil {
+				return
+			}
+			warned := false
+			for _, track := range tracks {
+				warned = warned || len(track.warnings) > 0
+			}
+			if !warned {
+				t.Errorf("%d of %d bytes: no error or warning", n, len(full))
+			}
+		}()
+	}
+}
+
+func TestParseMIDIMetaLengthPastTrack(t *testing.T) {
+	// a text meta event claiming far more data than the track holds
+	dat := smf(0, 96, trackChunk(event(0, 0xFF, 0x01, 0x8F, 0xFF, 0xFF, 0x7F, 'a')))
+	if _, err := ParseMIDI(bytes.NewReader(dat)); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestParseMidiFileGzip(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(testSMF()); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{
+		"song.mid":    {Data: testSMF()},
+		"song.mid.gz": {Data: compressed.Bytes()},
+	}
+
+	plain, err := parseMidiFile(discardLogger(), fsys, "song.mid")
+	if err != nil {
+		t.Fatalf("song.mid: %v", err)
+	}
+	unzipped, err := parseMidiFile(discardLogger(), fsys, "song.mid.gz")
+	if err != nil {
+		t.Fatalf("song.mid.gz: %v", err)
+	}
+	if len(unzipped) != 1 || len(unzipped[0].notes) != len(plain[0].notes) {
+		t.Fatalf("got %d tracks, want the %d notes of the plain file", len(unzipped), len(plain[0].notes))
+	}
+}
+
+func TestParseMidiFileGzipCorrupt(t *testing.T) {
+	fsys := fstest.MapFS{"song.mid.gz": {Data: testSMF()}}
+	if _, err := parseMidiFile(discardLogger(), fsys, "song.mid.gz"); err == nil {
+		t.Fatal("expected an error for a .gz file that isn't gzip data")
+	}
+}
+
+func TestToTrackUpsample(t *testing.T) {
+	midiTrack := parseTestMidi(t, testSMF())[0]
+	plain := midiTrack.ToTrack(discardLogger(), "test", Options{})
+	upsampled := midiTrack.ToTrack(discardLogger(), "test", Options{Upsample: 4})
+
+	if upsampled.ppqn != plain.ppqn*4 {
+		t.Fatalf("ppqn %d, want %d", upsampled.ppqn, plain.ppqn*4)
+	}
+	if len(upsampled.notes) != len(plain.notes) {
+		t.Fatalf("%d notes, want %d", len(upsampled.notes), len(plain.notes))
+	}
+	// the ticks are scaled with the ppqn, so every note keeps its time in seconds
+	for i, note := range upsampled.notes {
+		want := plain.notes[i]
+		if note.on != want.on*4 || note.off != want.off*4 {
+			t.Errorf("note %d: %d-%d, want %d-%d", i, note.on, note.off, want.on*4, want.off*4)
+		}
+		const us = 500000
+		got := deltaTimeToSeconds(note.off, us, int(upsampled.ppqn))
+		if wantSeconds := deltaTimeToSeconds(want.off, us, int(plain.ppqn)); got != wantSeconds {
+			t.Errorf("note %d: off at %vs, want %vs", i, got, wantSeconds)
+		}
+	}
+}
+
+func TestToTrackUpsampleOverflow(t *testing.T) {
+	midiTrack := parseTestMidi(t, testSMF())[0]
+	// 96 * 1000 doesn't fit the 16 bits of the ppqn
+	track := midiTrack.ToTrack(discardLogger(), "test", Options{Upsample: 1000})
+	if track.ppqn < midiTrack.ppqn || int(track.ppqn)%int(midiTrack.ppqn) != 0 {
+		t.Fatalf("ppqn %d isn't a multiple of %d", track.ppqn, midiTrack.ppqn)
+	}
+	if len(track.warnings) == 0 {
+		t.Error("expected a warning about the lowered upsample")
+	}
+}
+
+func TestParseMidiDivisionZero(t *testing.T) {
+	if _, err := ParseMIDI(bytes.NewReader(smf(0, 0, trackChunk()))); err == nil {
+		t.Fatal("expected an error for a division of 0")
+	}
+}
+
+func TestParseMidiSplitSysex(t *testing.T) {
+	// a sysex divided into an 0xF0 packet and two 0xF7 continuations, with a note between the packets,
+	// then an 0xF7 escape that isn't part of any sysex
+	dat := smf(0, 96, trackChunk(
+		event(0, 0xF0, 0x03, 0x43, 0x12, 0x00),
+		noteOnEvent(0, 0, 60, 100),
+		event(0, 0xF7, 0x02, 0x07, 0x40),
+		event(0, 0xF7, 0x02, 0x01, 0xF7),
+		noteOffEvent(96, 0, 60),
+		event(0, 0xF7, 0x01, 0xFA),
+	))
+
+	var log bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&log, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	midiTracks, err := parseMidi(logger, bytes.NewReader(dat))
+	if err != nil {
+		t.Fatalf("parseMidi: %v", err)
+	}
+
+	if n := len(midiTracks[0].notes); n != 2 {
+		t.Errorf("%d note events, want 2", n)
+	}
+	// the packets are grouped into one 7 byte message, the escape isn't counted as a message
+	if got := strings.Count(log.String(), `msg="Sysex Message Length"`); got != 1 {
+		t.Fatalf("%d sysex messages, want 1", got)
+	}
+	if !strings.Contains(log.String(), `msg="Sysex Message Length" length=7`) {
+		t.Errorf("sysex message isn't 7 bytes long:\n%s", log.String())
+	}
+}
+
+func TestParseMidiSysexPastTrack(t *testing.T) {
+	dat := smf(0, 96, trackChunk(event(0, 0xF0, 0x8F, 0xFF, 0xFF, 0x7F, 0x43)))
+	if _, err := ParseMIDI(bytes.NewReader(dat)); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestToTrackNoteOffBeforeNoteOn(t *testing.T) {
+	// parsed ticks never go back, so the track is built directly
+	midiTrack := NewMidiTrack()
+	midiTrack.ppqn = 96
+	midiTrack.notes = []MidiNote{
+		{tick: 96, eventType: NoteOn, note: 60, velocity: 100},
+		{tick: 48, eventType: NoteOff, note: 60},
+		{tick: 96, eventType: NoteOn, note: 62, velocity: 100},
+		{tick: 192, eventType: NoteOff, note: 62},
+	}
+
+	track := midiTrack.ToTrack(discardLogger(), "test", Options{})
+	if len(track.notes) != 1 || track.notes[0].num != 62 {
+		t.Fatalf("got notes %+v, want only note 62", track.notes)
+	}
+	if len(track.warnings) == 0 {
+		t.Error("expected a warning about the dropped note")
+	}
+}
+
+func TestToTrackNotePairing(t *testing.T) {
+	type span struct{ num, on, off int }
+	tests := []struct {
+		name   string
+		events [][]byte
+		want   []span
+	}{
+		{
+			// on/on/off/off of the same pitch merges into one note from the first on to the last off
+			name: "nested",
+			events: [][]byte{
+				noteOnEvent(0, 0, 60, 100),
+				noteOnEvent(24, 0, 60, 100),
+				noteOffEvent(24, 0, 60),
+				noteOffEvent(48, 0, 60),
+			},
+			want: []span{{60, 0, 96}},
+		},
+		{
+			name: "nested velocity 0 note offs",
+			events: [][]byte{
+				noteOnEvent(0, 0, 60, 100),
+				noteOnEvent(24, 0, 60, 100),
+				noteOnEvent(24, 0, 60, 0),
+				noteOnEvent(48, 0, 60, 0),
+			},
+			want: []span{{60, 0, 96}},
+		},
+		{
+			// different pitches pair up independently
+			name: "interleaved",
+			events: [][]byte{
+				noteOnEvent(0, 0, 60, 100),
+				noteOnEvent(24, 0, 64, 100),
+				noteOffEvent(24, 0, 60),
+				noteOnEvent(24, 0, 60, 0),
+				noteOffEvent(24, 0, 64),
+			},
+			want: []span{{60, 0, 48}, {64, 24, 96}},
+		},
+		{
+			// the same pitch on different channels is a different note
+			name: "channels",
+			events: [][]byte{
+				noteOnEvent(0, 0, 60, 100),
+				noteOnEvent(0, 1, 60, 100),
+				noteOffEvent(48, 1, 60),
+				noteOffEvent(48, 0, 60),
+			},
+			want: []span{{60, 0, 48}, {60, 0, 96}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			midiTrack := parseTestMidi(t, smf(0, 96, trackChunk(test.events...)))[0]
+			track := midiTrack.ToTrack(discardLogger(), "test", Options{})
+
+			if len(track.notes) != len(test.want) {
+				t.Fatalf("got notes %+v, want %v", track.notes, test.want)
+			}
+			for i, note := range track.notes {
+				if got := (span{note.num, note.on, note.off}); got != test.want[i] {
+					t.Errorf("note %d: got %v, want %v", i, got, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestToTrackLengthensShortNotes(t *testing.T) {
+	midiTrack := parseTestMidi(t, smf(0, 96, trackChunk(
+		// a zero length note, its on and off on the same tick
+		noteOnEvent(0, 0, 60, 100),
+		noteOffEvent(0, 0, 60),
+		noteOnEvent(48, 0, 62, 100),
+		noteOffEvent(96, 0, 62),
+	)))[0]
+
+	tests := []struct {
+		name         string
+		minNoteBeats float64
+		wantTicks    int
+	}{
+		// 96 / 64 rounds down to 1 tick
+		{"default", 0, 1},
+		{"quarter beat", 0.25, 24},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			track := midiTrack.ToTrack(discardLogger(), "test", Options{MinNoteBeats: test.minNoteBeats})
+			if len(track.notes) != 2 {
+				t.Fatalf("got notes %+v, want 2", track.notes)
+			}
+			if zero := track.notes[0]; zero.on != 0 || zero.off != test.wantTicks {
+				t.Errorf("zero length note is %d-%d, want 0-%d", zero.on, zero.off, test.wantTicks)
+			}
+			// notes already longer than the minimum keep their length
+			if long := track.notes[1]; long.off-long.on != 96 {
+				t.Errorf("long note is %d ticks, want 96", long.off-long.on)
+			}
+		})
+	}
+}
+
+func TestParseMidiTimeSignatureDenominator(t *testing.T) {
+	timeSignature := func(power byte) []byte {
+		return smf(0, 96, trackChunk(event(0, 0xFF, 0x58, 0x04, 0x03, power, 0x18, 0x08)))
+	}
+
+	midiTrack := parseTestMidi(t, timeSignature(3))[0]
+	if got := midiTrack.timeSignatureChanges[0]; got.numerator != 3 || got.denominator != 8 {
+		t.Errorf("got %d/%d, want 3/8", got.numerator, got.denominator)
+	}
+	if _, err := ParseMIDI(bytes.NewReader(timeSignature(maxDenominatorPower + 1))); err == nil {
+		t.Error("expected an error for a denominator of 2^7")
+	}
+	if _, err := ParseMIDI(bytes.NewReader(timeSignature(0xFF))); err == nil {
+		t.Error("expected an error for a denominator of 2^255")
+	}
+}
+
+func TestToTrackSkippedChannelEvents(t *testing.T) {
+	// pitch bend, channel pressure and poly pressure aren't stored, their delta times still count
+	midiTrack := parseTestMidi(t, smf(0, 96, trackChunk(
+		noteOnEvent(0, 0, 60, 100),
+		event(48, 0xE0, 0x00, 0x40),
+		event(24, 0xD0, 0x40),
+		event(12, 0xA0, 60, 0x40),
+		noteOffEvent(12, 0, 60),
+		noteOnEvent(0, 0, 62, 100),
+		noteOffEvent(96, 0, 62),
+	)))[0]
+
+	track := midiTrack.ToTrack(discardLogger(), "test", Options{})
+	if len(track.notes) != 2 {
+		t.Fatalf("got notes %+v, want 2", track.notes)
+	}
+	if note := track.notes[0]; note.on != 0 || note.off != 96 {
+		t.Errorf("first note is %d-%d, want 0-96", note.on, note.off)
+	}
+	if note := track.notes[1]; note.on != 96 || note.off != 192 {
+		t.Errorf("second note is %d-%d, want 96-192", note.on, note.off)
+	}
+}
+
+func TestToTrackTempoChangeAfterMetaEvents(t *testing.T) {
+	// in format 0 the markers, tempo changes and notes share a track, the notes have to be on the tempo map's ticks
+	midiTrack := parseTestMidi(t, smf(0, 96, trackChunk(
+		tempoEvent(0, 500000),
+		event(48, 0xFF, 0x06, 0x01, 'A'),
+		event(24, 0xFF, 0x01, 0x02, 'h', 'i'),
+		noteOnEvent(24, 0, 60, 100),
+		tempoEvent(0, 250000),
+		noteOffEvent(96, 0, 60),
+	)))[0]
+
+	track := midiTrack.ToTrack(discardLogger(), "test", Options{})
+	if len(track.notes) != 1 || len(track.tempoChanges) != 2 {
+		t.Fatalf("got notes %+v and tempo changes %+v", track.notes, track.tempoChanges)
+	}
+	note, change := track.notes[0], track.tempoChanges[1]
+	if note.on != 96 || change.tick != note.on {
+		t.Errorf("note on at %d, tempo change at %d, want both at 96", note.on, change.tick)
+	}
+	// a beat at 120 bpm then a beat at 240 bpm
+	if got := track.tickToSeconds(note.off); math.Abs(got-0.75) > 1e-9 {
+		t.Errorf("note off at %vs, want 0.75s", got)
+	}
+}
@@ -0,0 +1,90 @@
+package midivis
+
+import (
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+	"golang.org/x/image/colornames"
+)
+
+// Size and margin in pixels of the minimap, drawn in the top right corner of the screen
+const (
+	minimapWidth  = 256
+	minimapHeight = 64
+	minimapMargin = 8
+)
+
+// Minimap is an overview of every note of the song, with a rectangle around the part that's on screen
+type Minimap struct {
+	// image is the cached overview, rendered on the first draw since the notes don't move at this zoom
+	image *ebiten.Image
+}
+
+// render draws every note into the cached image, fitting the song's length and note range
+func (m *Minimap) render(g *Game) {
+	m.image = ebiten.NewImage(minimapWidth, minimapHeight)
+	m.image.Fill(color.RGBA{0, 0, 0, 0x99})
+	if len(g.notes) == 0 || g.songEndTick <= 0 {
+		return
+	}
+
+	noteMin, noteMax := 127, 0
+	for _, r := range g.notes {
+		for _, n := range renderedNotes(r) {
+			noteMin = min(noteMin, n.num)
+			noteMax = max(noteMax, n.num)
+		}
+	}
+
+	rowHeight := float32(minimapHeight) / float32(noteMax-noteMin+1)
+	for _, r := range g.notes {
+		for _, n := range renderedNotes(r) {
+			x := float32(n.on) / float32(g.songEndTick) * minimapWidth
+			w := max(float32(n.off-n.on)/float32(g.songEndTick)*minimapWidth, 1)
+			y := float32(noteMax-n.num) * rowHeight
+			vector.DrawFilledRect(m.image, x, y, w, max(rowHeight, 1), r.GetColor(), false)
+		}
+	}
+}
+
+// Draw draws the minimap and a rectangle around the ticks on screen
+func (m *Minimap) Draw(screen *ebiten.Image, g *Game) {
+	if m.image == nil {
+		m.render(g)
+	}
+
+	x0 := float32(g.width - minimapWidth - minimapMargin)
+	y0 := float32(minimapMargin)
+	opts := &ebiten.DrawImageOptions{}
+	opts.GeoM.Translate(float64(x0), float64(y0))
+	opts.GeoM.Scale(float64(g.scale), float64(g.scale))
+	screen.DrawImage(m.image, opts)
+
+	if g.songEndTick <= 0 {
+		return
+	}
+
+	startTick, endTick := g.ticksOnScreen()
+	startX := min(max(float32(startTick)/float32(g.songEndTick), 0), 1) * minimapWidth
+	endX := min(max(float32(endTick)/float32(g.songEndTick), 0), 1) * minimapWidth
+	vector.StrokeRect(screen, (x0+startX)*g.scale, y0*g.scale, max(endX-startX, 1)*g.scale, minimapHeight*g.scale, g.scale, colornames.White, false)
+}
+
+// Update seeks to the clicked tick when the minimap is clicked
+func (m *Minimap) Update(g *Game) error {
+	if !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		return nil
+	}
+
+	cx, cy := g.cursorPosition()
+	x0 := float32(g.width - minimapWidth - minimapMargin)
+	y0 := float32(minimapMargin)
+	if cx < x0 || cx > x0+minimapWidth || cy < y0 || cy > y0+minimapHeight {
+		return nil
+	}
+
+	pct := (cx - x0) / minimapWidth
+	return g.seekToTick(int(pct * float32(g.songEndTick)))
+}
@@ -0,0 +1,543 @@
+package midivis
+
+import (
+	"image/color"
+	"sort"
+
+	"github.com/hajimehoshi/ebiten/v2"
+)
+
+const (
+	NoteTypeRect = iota
+	NoteTypeScreen
+	NoteTypeMeter
+	NoteTypeZoom
+	NoteTypeRadialGradient
+	NoteTypeContour
+	NoteTypeChord
+)
+
+// noteTypes are the note types cycled through by the demo mode, chords are left out since they're grouped on load
+var noteTypes = []int{
+	NoteTypeRect,
+	NoteTypeScreen,
+	NoteTypeMeter,
+	NoteTypeZoom,
+	NoteTypeRadialGradient,
+	NoteTypeContour,
+}
+
+// Map midi files to animation types
+var fileNameToType = map[string]int{
+	"ah.mid":                NoteTypeRadialGradient,
+	"bridgevocalbottom.mid": NoteTypeRect,
+	"bridgevocaltop.mid":    NoteTypeRect,
+	"cleanvocalsbottom.mid": NoteTypeRect,
+	"click.mid":             NoteTypeRadialGradient,
+	"cymbalend.mid":         NoteTypeMeter,
+	"endbass.mid":           NoteTypeZoom,
+	"flutevoice.mid":        NoteTypeZoom,
+	"introvocals.mid":       NoteTypeRect,
+	"kick.mid":              NoteTypeRadialGradient,
+	"mainvocaltop.mid":      NoteTypeRect,
+	"oohchords.mid":         NoteTypeZoom,
+	"plucky.mid":            NoteTypeRect,
+	"shew.mid":              NoteTypeZoom,
+	"shimmery.mid":          NoteTypeRect,
+	"shimmeryfast.mid":      NoteTypeRect,
+	"shimmeryfastbass.mid":  NoteTypeZoom,
+	"slidey.mid":            NoteTypeZoom,
+}
+
+// Default z-index for each note type, used when not set by the config
+var noteTypeZ = map[int]int{
+	NoteTypeRect:           0,
+	NoteTypeScreen:         -10,
+	NoteTypeMeter:          -5,
+	NoteTypeZoom:           -1,
+	NoteTypeRadialGradient: 0,
+	NoteTypeContour:        0,
+	NoteTypeChord:          0,
+}
+
+type RenderableNoteBase struct {
+	Note
+	z     int // z-index, used for rendering order
+	color *color.RGBA
+}
+
+// NoteRect animates a rectangle across the screen during play
+type NoteRect struct {
+	RenderableNoteBase
+	xScale float64
+	// tie is the next note of the track on the same channel starting as this one ends, nil if there's none
+	tie *Note
+}
+
+// NoteScreen fills entire screen with color during play
+type NoteScreen struct {
+	RenderableNoteBase
+}
+
+// NoteMeter animates a rectangle from left to right during play filling screen
+type NoteMeter struct {
+	RenderableNoteBase
+	// velocityHeight scales the height of the meter by the note's velocity
+	velocityHeight bool
+}
+
+// NoteZoom animates a rectangle from center to full width during play
+type NoteZoom struct {
+	RenderableNoteBase
+	// freezeWidth and freezeHeight are the dimensions drawn at full size rather than growing before the note on,
+	// both grow by default
+	freezeWidth  bool
+	freezeHeight bool
+}
+
+// NoteRadialGradient animates a radial gradient from center to full width during play
+type NoteRadialGradient struct {
+	RenderableNoteBase
+}
+
+// NoteContour draws a line from the note to the next note of its track, showing the melodic contour
+type NoteContour struct {
+	RenderableNoteBase
+	// next is the next note of the track by note on, nil for the last note
+	next *Note
+}
+
+// NoteChord draws the notes starting on the same tick and channel as one shape spanning their pitches.
+// Its Note is the lowest note, lasting until the last of the notes turns off
+type NoteChord struct {
+	RenderableNoteBase
+	// notes are the notes of the chord, sorted by pitch
+	notes []Note
+}
+
+type Renderable interface {
+	GetZ() int
+	GetNote() Note
+	GetColor() *color.RGBA
+	Draw(screen *ebiten.Image, g *Game)
+}
+
+func (o *RenderableNoteBase) GetZ() int {
+	return o.z
+}
+
+func (o *RenderableNoteBase) GetNote() Note {
+	return o.Note
+}
+
+func (o *RenderableNoteBase) GetColor() *color.RGBA {
+	return o.color
+}
+
+func (o *NoteRect) Draw(screen *ebiten.Image, g *Game) {
+	// finished notes fade out and stop being drawn once they're FadeFinished beats past their note off
+	strokeColor := o.color
+	if g.opts.FadeFinished > 0 && o.off < g.elapsedDeltaTime {
+		fadeTicks := g.opts.FadeFinished * float64(g.ppqn)
+		pastOff := float64(g.elapsedDeltaTime - o.off)
+		if pastOff >= fadeTicks {
+			return
+		}
+		faded := dimColor(*o.color, 1-pastOff/fadeTicks)
+		strokeColor = &faded
+	}
+
+	// Draw the object
+	noteY, noteHeight := g.noteRow(o.Note)
+
+	isBeingPlayed := o.on <= g.elapsedDeltaTime && g.elapsedDeltaTime <= o.off
+
+	noteX, noteWidth, xScaleVel := g.rectSpan(o.Note)
+
+	if g.opts.QuantizePreview {
+		// ghost of the note snapped to the nearest 16th note
+		snappedOn := quantizeTick(o.on, g.ppqn/4)
+		ghostX := float32(snappedOn-g.elapsedDeltaTime)*xScaleVel + float32(g.xTranslate) + g.panOffset(o.Note)
+		g.fillRect(screen, ghostX, noteY, noteWidth, noteHeight, dimColor(*o.color, 0.25))
+	}
+
+	if g.opts.Ties && o.tie != nil {
+		g.drawTie(screen, o.Note, *o.tie, strokeColor)
+	}
+
+	if isBeingPlayed {
+		g.drawEchoes(screen, noteX, noteY, noteWidth, noteHeight, xScaleVel, o.color)
+
+		accentY, accentHeight := g.accentRow(o.Note, noteY, noteHeight)
+		g.fillRect(screen, noteX, accentY, noteWidth, accentHeight, o.color)
+
+		// set the blur Y position to the note's Y position
+		g.radialBlurShaderOpts.Uniforms["Center"] = []float32{float32(g.width) / 2.0 * g.scale, noteY * g.scale}
+	} else {
+		strokeWidth := float32(g.opts.StrokeWidth)
+		g.strokeRect(screen, noteX, noteY, noteWidth, noteHeight, strokeWidth, strokeColor)
+	}
+	g.drawOverlapOutline(screen, o.Note, noteX, noteY, noteWidth, noteHeight)
+
+	// only hit test notes that are on screen
+	isVisible := noteX+noteWidth >= 0 && noteX <= float32(g.width)
+	if isVisible {
+		cx, cy := g.cursorPosition()
+		if noteX <= cx && cx <= noteX+noteWidth && noteY <= cy && cy <= noteY+noteHeight {
+			g.hoveredNote = &o.Note
+		}
+	}
+}
+
+// rectSpan returns the x and width a rect note is drawn at, and its pixels per tick
+func (g *Game) rectSpan(n Note) (float32, float32, float32) {
+	// set arbitrary velocity minimum and scale from there
+	velMin := 100
+	velRange := 127 - velMin
+	xScaleVel := float32(((velMin - n.vel) / velRange) + 1)
+	if g.opts.PixelsPerBeat > 0 {
+		// a fixed scroll speed, the same for every note regardless of the tempo and ppqn
+		xScaleVel = g.pixelsPerTick()
+	}
+	displayOn, displayOff := g.displayTicks(n)
+	noteX := float32(displayOn-g.elapsedDeltaTime)*xScaleVel + float32(g.xTranslate) + g.panOffset(n)
+	noteWidth := float32(displayOff-displayOn) * xScaleVel
+
+	return noteX, noteWidth, xScaleVel
+}
+
+// drawTie draws a line from the end of a rect note to the start of the note it's tied to, when it's on screen
+func (g *Game) drawTie(screen *ebiten.Image, from Note, to Note, clr *color.RGBA) {
+	fromX, fromWidth, _ := g.rectSpan(from)
+	toX, _, _ := g.rectSpan(to)
+	x0, x1 := fromX+fromWidth, toX
+	if max(x0, x1) < 0 || min(x0, x1) > float32(g.width) {
+		return
+	}
+
+	y0, y1 := g.contourY(from), g.contourY(to)
+	g.strokeLine(screen, x0, y0, x1, y1, float32(g.opts.StrokeWidth), clr)
+}
+
+// linkTies links each rect note to a note of the same track and channel starting on its note off, the closest in pitch,
+// so legato phrases are drawn connected
+func linkTies(renderables []Renderable) {
+	type tieKey struct {
+		on      int
+		channel int
+	}
+
+	pitchDistance := func(a, b Note) int {
+		return max(a.num-b.num, b.num-a.num)
+	}
+
+	starting := map[tieKey][]Note{}
+	for _, r := range renderables {
+		n := r.GetNote()
+		key := tieKey{on: n.on, channel: n.channel}
+		starting[key] = append(starting[key], n)
+	}
+
+	for _, r := range renderables {
+		rect, ok := r.(*NoteRect)
+		if !ok {
+			continue
+		}
+		for _, next := range starting[tieKey{on: rect.off, channel: rect.channel}] {
+			if rect.tie == nil || pitchDistance(next, rect.Note) < pitchDistance(*rect.tie, rect.Note) {
+				rect.tie = &next
+			}
+		}
+	}
+}
+
+func (o *NoteScreen) Draw(screen *ebiten.Image, g *Game) {
+	// cover screen with color
+	isBeingPlayed := o.on <= g.elapsedDeltaTime && g.elapsedDeltaTime <= o.off
+	if isBeingPlayed {
+		g.fillScreen(screen, o.color)
+	}
+}
+
+func (o *NoteMeter) Draw(screen *ebiten.Image, g *Game) {
+	// zoom in from small to large, filling up width of screen when being played
+	deltaThreshold := g.opts.Config.leadInTicks(NoteTypeMeter, g.ppqn)
+
+	// hasn't started
+	if o.on-deltaThreshold > g.elapsedDeltaTime {
+		return
+	}
+
+	// already finished
+	if o.off < g.elapsedDeltaTime {
+		return
+	}
+
+	noteX := g.panOffset(o.Note)
+	// noteY := o.num * g.noteHeight
+	// Draw the object
+	noteY, noteHeight := g.noteRow(o.Note)
+	if o.velocityHeight {
+		// keep the thinner meter centered in the row
+		velocityHeight := max(noteHeight*float32(o.vel)/127, 1)
+		noteY += (noteHeight - velocityHeight) / 2
+		noteHeight = velocityHeight
+	}
+
+	isBeingPlayed := o.on <= g.elapsedDeltaTime && g.elapsedDeltaTime <= o.off
+	if !isBeingPlayed {
+		// the meter starts out full width
+		g.drawPreviewOutline(screen, o.Note, deltaThreshold, noteX, noteY, float32(g.width), noteHeight, o.color)
+	} else {
+		pctUntilPlayStarts := float32(g.elapsedDeltaTime-o.on) / float32(deltaThreshold)
+		// flip it
+		pctUntilPlayStarts = 1 - pctUntilPlayStarts
+		pctUntilPlayStarts = float32(ease(float64(pctUntilPlayStarts), g.opts.Config.easingFor(NoteTypeMeter)))
+		// width goes from 0 to width of screen
+		noteWidth := float32(g.width) * pctUntilPlayStarts
+		accentY, accentHeight := g.accentRow(o.Note, noteY, noteHeight)
+		g.fillRect(screen, noteX, accentY, noteWidth, accentHeight, o.color)
+		g.drawOverlapOutline(screen, o.Note, noteX, noteY, noteWidth, noteHeight)
+	}
+}
+
+func (o *NoteZoom) Draw(screen *ebiten.Image, g *Game) {
+	// zoom in from small to large, filling up width of screen when being played
+	deltaThreshold := g.opts.Config.leadInTicks(NoteTypeZoom, g.ppqn)
+
+	// hasn't started
+	if o.on-deltaThreshold > g.elapsedDeltaTime {
+		return
+	}
+
+	// already finished
+	if o.off < g.elapsedDeltaTime {
+		return
+	}
+
+	tUntilOn := max(float32(o.on-g.elapsedDeltaTime), 0.0)
+	pctUntilPlayStarts := tUntilOn / float32(deltaThreshold)
+	// flip it, so 0 is at beginning of threshold, 1 as at note on
+	pctUntilPlayStarts = 1 - pctUntilPlayStarts
+	pctUntilPlayStarts = float32(ease(float64(pctUntilPlayStarts), g.opts.Config.easingFor(NoteTypeZoom)))
+	pctWidth, pctHeight := pctUntilPlayStarts, pctUntilPlayStarts
+	if o.freezeWidth {
+		pctWidth = 1
+	}
+	if o.freezeHeight {
+		pctHeight = 1
+	}
+
+	// x is between 0 and width / 2
+	noteX := float32(g.width) / 2 * pctWidth
+	// flip x so it goes from width / 2 to 0
+	noteX = float32(g.width)/2 - noteX
+	distToMiddle := float32(g.width)/2 - noteX
+	noteWidth := distToMiddle * 2
+	noteX += g.panOffset(o.Note)
+
+	noteY, rowHeight := g.noteRow(o.Note)
+	noteHeight := rowHeight * pctHeight
+
+	isBeingPlayed := o.on <= g.elapsedDeltaTime && g.elapsedDeltaTime <= o.off
+	if isBeingPlayed {
+		accentY, accentHeight := g.accentRow(o.Note, noteY, noteHeight)
+		g.fillRect(screen, noteX, accentY, noteWidth, accentHeight, o.color)
+	} else {
+		strokeWidth := float32(g.opts.StrokeWidth)
+		g.strokeRect(screen, noteX, noteY, noteWidth, noteHeight, strokeWidth, o.color)
+	}
+	g.drawOverlapOutline(screen, o.Note, noteX, noteY, noteWidth, noteHeight)
+}
+
+func (o *NoteRadialGradient) Draw(screen *ebiten.Image, g *Game) {
+	isBeingPlayed := o.on <= g.elapsedDeltaTime && g.elapsedDeltaTime <= o.off
+	alreadyHandled := g.radialGradientShaderOpts.Uniforms["PctShow"] != 0
+
+	if !isBeingPlayed || alreadyHandled {
+		return
+	}
+
+	pctShow := float32(g.elapsedDeltaTime-o.on) / float32(o.off-o.on)
+	g.radialGradientShaderOpts.Uniforms["PctShow"] = 1 - pctShow
+	g.radialGradientShaderOpts.Uniforms["Color"] = []float32{float32(o.color.R), float32(o.color.G), float32(o.color.B), float32(o.color.A)}
+}
+
+func (o *NoteContour) Draw(screen *ebiten.Image, g *Game) {
+	// the contour scrolls with the other notes
+	tickX := func(tick int) float32 {
+		return float32(tick-g.elapsedDeltaTime)*g.pixelsPerTick() + float32(g.xTranslate)
+	}
+	x := tickX(o.on)
+	y := g.contourY(o.Note)
+
+	// the last note holds its pitch until its note off
+	nextX := tickX(o.off)
+	nextY := y
+	if o.next != nil {
+		nextX = tickX(o.next.on)
+		nextY = g.contourY(*o.next)
+	}
+
+	// only draw segments near the playhead
+	if nextX < 0 || x > float32(g.width) {
+		return
+	}
+
+	strokeWidth := float32(2)
+	g.strokeLine(screen, x, y, nextX, nextY, strokeWidth, o.color)
+}
+
+func (o *NoteChord) Draw(screen *ebiten.Image, g *Game) {
+	displayOn, displayOff := g.displayTicks(o.Note)
+	noteX := float32(displayOn-g.elapsedDeltaTime)*g.pixelsPerTick() + float32(g.xTranslate) + g.panOffset(o.Note)
+	noteWidth := float32(displayOff-displayOn) * g.pixelsPerTick()
+
+	// only draw chords that are on screen
+	if noteX+noteWidth < 0 || noteX > float32(g.width) {
+		return
+	}
+
+	lowY, lowHeight := g.noteRow(o.notes[0])
+	topY, _ := g.noteRow(o.notes[len(o.notes)-1])
+	chordHeight := lowY + lowHeight - topY
+
+	isBeingPlayed := o.on <= g.elapsedDeltaTime && g.elapsedDeltaTime <= o.off
+	if isBeingPlayed {
+		g.fillRect(screen, noteX, topY, noteWidth, chordHeight, dimColor(*o.color, 0.3))
+		for _, n := range o.notes {
+			noteY, noteHeight := g.noteRow(n)
+			on, off := g.displayTicks(n)
+			g.fillRect(screen, noteX, noteY, float32(off-on)*g.pixelsPerTick(), noteHeight, o.color)
+		}
+	}
+
+	strokeWidth := float32(g.opts.StrokeWidth)
+	g.strokeRect(screen, noteX, topY, noteWidth, chordHeight, strokeWidth, o.color)
+}
+
+// groupChords merges the chord renderables of a track starting on the same tick and channel into one chord each
+func groupChords(renderables []Renderable) []Renderable {
+	type chordKey struct {
+		on      int
+		channel int
+	}
+
+	grouped := make([]Renderable, 0, len(renderables))
+	chords := map[chordKey]*NoteChord{}
+	for _, r := range renderables {
+		chord, ok := r.(*NoteChord)
+		if !ok {
+			grouped = append(grouped, r)
+			continue
+		}
+
+		key := chordKey{on: chord.on, channel: chord.channel}
+		existing, ok := chords[key]
+		if !ok {
+			chords[key] = chord
+			grouped = append(grouped, chord)
+			continue
+		}
+		existing.notes = append(existing.notes, chord.notes...)
+	}
+
+	for _, chord := range chords {
+		sort.Slice(chord.notes, func(i, j int) bool {
+			return chord.notes[i].num < chord.notes[j].num
+		})
+		chord.Note = chord.notes[0]
+		for _, n := range chord.notes {
+			chord.off = max(chord.off, n.off)
+			chord.vel = max(chord.vel, n.vel)
+		}
+	}
+
+	return grouped
+}
+
+// renderedNotes returns the notes drawn by the renderable, every note of a chord or the renderable's note
+func renderedNotes(r Renderable) []Note {
+	if chord, ok := r.(*NoteChord); ok {
+		return chord.notes
+	}
+	return []Note{r.GetNote()}
+}
+
+// linkContour sorts the contour notes of a track by note on and links each one to the next
+func linkContour(renderables []Renderable) {
+	sort.SliceStable(renderables, func(i, j int) bool {
+		return renderables[i].GetNote().on < renderables[j].GetNote().on
+	})
+
+	for i := 0; i < len(renderables)-1; i++ {
+		contour, ok := renderables[i].(*NoteContour)
+		if !ok {
+			continue
+		}
+		next := renderables[i+1].GetNote()
+		contour.next = &next
+	}
+}
+
+// setupTrackNotes does the setup of a track's renderables that depends on their note types, applying the file's
+// meter and zoom settings, linking contours and ties and grouping chords. It returns the renderables with the chords merged
+func setupTrackNotes(renderables []Renderable, fileConfig *FileConfig, ties bool) []Renderable {
+	hasContour, hasChord, hasRect := false, false, false
+	for _, r := range renderables {
+		switch r := r.(type) {
+		case *NoteMeter:
+			r.velocityHeight = fileConfig.velocityHeight()
+		case *NoteZoom:
+			animateWidth, animateHeight := fileConfig.zoomAnimates()
+			r.freezeWidth, r.freezeHeight = !animateWidth, !animateHeight
+		case *NoteContour:
+			hasContour = true
+		case *NoteChord:
+			hasChord = true
+		case *NoteRect:
+			hasRect = true
+		}
+	}
+
+	if hasContour {
+		linkContour(renderables)
+	}
+	if hasChord {
+		renderables = groupChords(renderables)
+	}
+	if ties && hasRect {
+		linkTies(renderables)
+	}
+	return renderables
+}
+
+// newRenderable creates the renderable for a note of the given note type
+func newRenderable(noteType int, note Note, z int, c *color.RGBA, noteIndex int) Renderable {
+	base := RenderableNoteBase{
+		Note:  note,
+		z:     z,
+		color: c,
+	}
+
+	switch noteType {
+	case NoteTypeScreen:
+		return &NoteScreen{RenderableNoteBase: base}
+	case NoteTypeMeter:
+		return &NoteMeter{RenderableNoteBase: base}
+	case NoteTypeZoom:
+		return &NoteZoom{RenderableNoteBase: base}
+	case NoteTypeRadialGradient:
+		return &NoteRadialGradient{RenderableNoteBase: base}
+	case NoteTypeContour:
+		return &NoteContour{RenderableNoteBase: base}
+	case NoteTypeChord:
+		return &NoteChord{RenderableNoteBase: base, notes: []Note{note}}
+	default:
+		xScale := 2.0
+		if noteIndex%2 == 0 {
+			xScale = 1
+		}
+		return &NoteRect{RenderableNoteBase: base, xScale: xScale}
+	}
+}
@@ -0,0 +1,85 @@
+package midivis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+)
+
+// oscClient sends note events as OSC messages over UDP, batching the messages of each update into one bundle
+type oscClient struct {
+	conn net.Conn
+	// messages are the encoded messages waiting for the next flush
+	messages [][]byte
+}
+
+func newOSCClient(address string) (*oscClient, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oscClient{conn: conn}, nil
+}
+
+// queueNoteEvent queues a /note/on or /note/off message with the pitch, velocity and channel of the note
+func (c *oscClient) queueNoteEvent(n Note, kind string) {
+	c.messages = append(c.messages, encodeOSCMessage("/note/"+kind, int32(n.num), int32(n.vel), int32(n.channel)))
+}
+
+// flush sends the queued messages, a single message is sent as is and several as a bundle
+func (c *oscClient) flush() error {
+	if len(c.messages) == 0 {
+		return nil
+	}
+
+	packet := c.messages[0]
+	if len(c.messages) > 1 {
+		packet = encodeOSCBundle(c.messages)
+	}
+	c.messages = c.messages[:0]
+
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+// writeOSCString writes an OSC string, null terminated and padded with nulls to a multiple of 4 bytes
+func writeOSCString(buf *bytes.Buffer, s string) {
+	buf.WriteString(s)
+	buf.Write(make([]byte, 4-len(s)%4))
+}
+
+// encodeOSCMessage encodes a message of int32 arguments
+func encodeOSCMessage(address string, args ...int32) []byte {
+	var buf bytes.Buffer
+	writeOSCString(&buf, address)
+
+	typeTags := ","
+	for range args {
+		typeTags += "i"
+	}
+	writeOSCString(&buf, typeTags)
+
+	for _, arg := range args {
+		binary.Write(&buf, binary.BigEndian, arg)
+	}
+
+	return buf.Bytes()
+}
+
+// oscTimeTagImmediately is the time tag of bundles handled as soon as they're received
+const oscTimeTagImmediately = 1
+
+// encodeOSCBundle encodes messages into a bundle handled immediately
+func encodeOSCBundle(messages [][]byte) []byte {
+	var buf bytes.Buffer
+	writeOSCString(&buf, "#bundle")
+	binary.Write(&buf, binary.BigEndian, uint64(oscTimeTagImmediately))
+
+	for _, message := range messages {
+		binary.Write(&buf, binary.BigEndian, int32(len(message)))
+		buf.Write(message)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,139 @@
+package midivis
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"math/cmplx"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+	"golang.org/x/image/colornames"
+)
+
+const (
+	// spectrumWindow is the number of frames around the playhead each spectrum is computed from, a power of 2 for the FFT
+	spectrumWindow = 1024
+	// spectrumBands is the number of bars drawn, the frequencies are split logarithmically between them
+	spectrumBands = 64
+	// spectrumRangeDB is the range of magnitudes in decibels from an empty bar to a full one
+	spectrumRangeDB = 60
+	// spectrumMaxHeight is the fraction of the screen's height filled by a full bar
+	spectrumMaxHeight = 0.5
+)
+
+// spectrumColor is faint so the bars stay behind the notes
+var spectrumColor = dimColor(colornames.White, 0.15)
+
+// Spectrum is the magnitude spectrum of the audio around the playhead, drawn as faint bars behind the notes
+type Spectrum struct {
+	// stream is a decoded float32 stream of the audio, separate from the player's so seeking it doesn't affect playback
+	stream     io.ReadSeeker
+	sampleRate int
+	buf        []byte
+	samples    []complex128
+	// bands holds the magnitude (0 to 1) of each bar
+	bands []float64
+	// frame is the first frame of the window the bands were computed from, the bands are only recomputed when it moves
+	frame int64
+}
+
+// NewSpectrum creates a Spectrum reading from a float32 decoded stream of the audio
+func NewSpectrum(stream io.ReadSeeker, sampleRate int) *Spectrum {
+	return &Spectrum{
+		stream:     stream,
+		sampleRate: sampleRate,
+		buf:        make([]byte, spectrumWindow*bytesPerF32Frame),
+		samples:    make([]complex128, spectrumWindow),
+		bands:      make([]float64, spectrumBands),
+		frame:      -1,
+	}
+}
+
+// Update computes the spectrum of the window centered on the playhead
+func (s *Spectrum) Update(g *Game) error {
+	// the song starts audioStart into the audio file
+	seconds := g.tempoMap.tickToSeconds(g.elapsedDeltaTime) + g.audioStart().Seconds()
+	frame := max(int64(seconds*float64(s.sampleRate))-spectrumWindow/2, 0)
+	if frame == s.frame {
+		return nil
+	}
+	s.frame = frame
+
+	if _, err := s.stream.Seek(frame*bytesPerF32Frame, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.ReadFull(s.stream, s.buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	for i := range s.samples {
+		// frames past the end of the audio are silent
+		mono := 0.0
+		if offset := i * bytesPerF32Frame; offset+bytesPerF32Frame <= n {
+			left := math.Float32frombits(binary.LittleEndian.Uint32(s.buf[offset:]))
+			right := math.Float32frombits(binary.LittleEndian.Uint32(s.buf[offset+4:]))
+			mono = float64(left+right) / 2
+		}
+		// a Hann window keeps the edges of the window from smearing the spectrum
+		hann := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/(spectrumWindow-1))
+		s.samples[i] = complex(mono*hann, 0)
+	}
+	fft(s.samples)
+
+	// only the first half of the bins are unique for real samples, the DC bin is skipped
+	bins := float64(spectrumWindow / 2)
+	for b := range s.bands {
+		lo := int(math.Pow(bins, float64(b)/spectrumBands))
+		hi := max(int(math.Pow(bins, float64(b+1)/spectrumBands)), lo+1)
+		peak := 0.0
+		for k := lo; k < hi; k++ {
+			peak = max(peak, cmplx.Abs(s.samples[k]))
+		}
+
+		// a full scale sine wave peaks at a quarter of the window with the Hann window
+		db := 20 * math.Log10(peak/(spectrumWindow/4)+1e-9)
+		s.bands[b] = min(max(1+db/spectrumRangeDB, 0), 1)
+	}
+
+	return nil
+}
+
+// Draw draws the bands as bars rising from the bottom of the screen
+func (s *Spectrum) Draw(screen *ebiten.Image, g *Game) {
+	barWidth := float32(g.width) / spectrumBands
+	for b, magnitude := range s.bands {
+		barHeight := float32(magnitude) * spectrumMaxHeight * float32(g.height)
+		x := float32(b) * barWidth
+		vector.DrawFilledRect(screen, x*g.scale, (float32(g.height)-barHeight)*g.scale, (barWidth-1)*g.scale, barHeight*g.scale, spectrumColor, false)
+	}
+}
+
+// fft replaces x with its discrete Fourier transform, len(x) must be a power of 2
+func fft(x []complex128) {
+	n := len(x)
+
+	// reorder into bit reversed order so the butterflies can run in place
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := -2 * math.Pi / float64(size)
+		for start := 0; start < n; start += size {
+			for k := 0; k < size/2; k++ {
+				twiddle := cmplx.Exp(complex(0, step*float64(k)))
+				a, b := x[start+k], x[start+k+size/2]*twiddle
+				x[start+k], x[start+k+size/2] = a+b, a-b
+			}
+		}
+	}
+}
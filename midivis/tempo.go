@@ -0,0 +1,82 @@
+package midivis
+
+// TempoMap converts between ticks and seconds across the tempo changes of a track.
+// Before the first change the tempo is defaultMicroSecondsPerQuarterNote
+type TempoMap struct {
+	// changes are in tick order
+	changes []TempoChange
+	ppqn    int
+}
+
+// tickToSeconds returns the time in seconds of a tick, adding up the segments between the tempo changes before it
+func (m TempoMap) tickToSeconds(tick int) float64 {
+	seconds := 0.0
+	segmentStart := 0
+	tempo := defaultMicroSecondsPerQuarterNote
+	for _, change := range m.changes {
+		if change.tick >= tick {
+			break
+		}
+		seconds += deltaTimeToSeconds(change.tick-segmentStart, tempo, m.ppqn)
+		segmentStart = change.tick
+		tempo = change.microSecondsPerQuarterNote
+	}
+
+	return seconds + deltaTimeToSeconds(tick-segmentStart, tempo, m.ppqn)
+}
+
+// secondsToTick returns the tick at a time in seconds, rounded to the nearest tick
+func (m TempoMap) secondsToTick(seconds float64) int {
+	segmentSeconds := 0.0
+	segmentStart := 0
+	tempo := defaultMicroSecondsPerQuarterNote
+	for _, change := range m.changes {
+		changeSeconds := segmentSeconds + deltaTimeToSeconds(change.tick-segmentStart, tempo, m.ppqn)
+		if changeSeconds > seconds {
+			break
+		}
+		segmentSeconds = changeSeconds
+		segmentStart = change.tick
+		tempo = change.microSecondsPerQuarterNote
+	}
+
+	return segmentStart + secondsToDeltaTime(seconds-segmentSeconds, tempo, m.ppqn)
+}
+
+// tempoAt returns the microseconds per quarter note at a tick
+func (m TempoMap) tempoAt(tick int) int {
+	tempo := defaultMicroSecondsPerQuarterNote
+	for _, change := range m.changes {
+		if change.tick > tick {
+			break
+		}
+		tempo = change.microSecondsPerQuarterNote
+	}
+	return tempo
+}
+
+// tempoMap returns the tempo map of the track's Set Tempo events
+func (t *Track) tempoMap() TempoMap {
+	return TempoMap{changes: t.tempoChanges, ppqn: int(t.ppqn)}
+}
+
+// tickToSeconds returns the time in seconds of a tick of the track, following its tempo changes
+func (t *Track) tickToSeconds(tick int) float64 {
+	return t.tempoMap().tickToSeconds(tick)
+}
+
+// secondsToTick returns the tick of the track at a time in seconds, following its tempo changes
+func (t *Track) secondsToTick(seconds float64) int {
+	return t.tempoMap().secondsToTick(seconds)
+}
+
+// songTempoMap returns the tempo map of the first track with a Set Tempo event, usually the conductor track,
+// timed in ppqn ticks like the game
+func songTempoMap(tracks []*Track, ppqn int) TempoMap {
+	for _, t := range tracks {
+		if len(t.tempoChanges) > 0 {
+			return TempoMap{changes: t.tempoChanges, ppqn: ppqn}
+		}
+	}
+	return TempoMap{ppqn: ppqn}
+}
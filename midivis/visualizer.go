@@ -0,0 +1,778 @@
+package midivis
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/audio"
+	"golang.org/x/image/colornames"
+)
+
+// Options holds the settings of a Visualizer, zero values use the defaults
+type Options struct {
+	// Logger receives parsing and rendering logs, defaults to discarding them
+	Logger *slog.Logger
+	// Debug prints the player position and measure on screen
+	Debug bool
+	// Config describes how each track is rendered, defaults to NewRenderConfig()
+	Config *RenderConfig
+	// TypeMapping maps file names to note types after the config's types and before the built-in ones, nil maps nothing
+	TypeMapping *TypeMapping
+	// AudioFile is the mp3, wav or ogg file played along with the tracks, drives the timing while it plays.
+	// When it's missing or fails to decode the tracks are timed by the tick clock alone
+	AudioFile string
+	// AudioFS is the file system AudioFile is read from, nil reads it from the OS's file system
+	AudioFS fs.FS
+	// FS is the file system LoadFile, LoadFiles and Check read midi files from, nil reads them from the OS's file system
+	FS fs.FS
+	// AudioFormat is the sample format the audio is decoded to, AudioFormatF32 or AudioFormatS16. Defaults to AudioFormatF32
+	AudioFormat string
+	// FPS is the number of updates per second, also used by the tick clock when there's no audio playing. Defaults to 60
+	FPS int
+	// Transpose shifts the pitch of every note by this many semitones
+	Transpose int
+	// Upsample multiplies the ppqn and note ticks of the tracks for smoother animations of low ppqn files
+	Upsample int
+	// ShakeIntensity is the max camera shake offset in pixels, 0 disables shaking
+	ShakeIntensity float64
+	// ShakeDecay is multiplied into the current shake amount every frame
+	ShakeDecay float64
+	// ShakeFile is the midi file whose notes trigger a shake
+	ShakeFile string
+	// ShakeVelocity is the minimum velocity for a note to trigger a shake
+	ShakeVelocity int
+	// PanWidth is the max horizontal offset in pixels of notes on hard panned channels, 0 ignores pan
+	PanWidth float64
+	// PreviewOutlines draws a faint outline of upcoming notes for renderers that only draw while playing
+	PreviewOutlines bool
+	// QuantizePreview draws a ghost of each note snapped to the nearest 16th note
+	QuantizePreview bool
+	// QuantizeDisplay draws rect and chord notes snapped to the nearest 1/QuantizeDisplay note, e.g. 16 for 16th notes,
+	// without changing when they play. 0 draws them at their exact ticks
+	QuantizeDisplay int
+	// Waveform draws the audio's amplitude envelope along the bottom of the screen, click it to seek
+	Waveform bool
+	// NoBlur draws the notes directly to the screen, skipping the radial blur and radial gradient shaders
+	NoBlur bool
+	// BlurStrength scales the distance of the radial blur's samples. Defaults to 1
+	BlurStrength float64
+	// BlurSensitivity is added to the blur strength for every full velocity note playing
+	BlurSensitivity float64
+	// Spectrum draws the frequency spectrum of the audio around the playhead as faint bars behind the notes
+	Spectrum bool
+	// Minimap draws an overview of the whole song in the top right corner, click it to seek
+	Minimap bool
+	// Lanes draws each track in its own horizontal band of the screen, fit to the track's note range,
+	// so tracks with overlapping pitches don't draw on top of each other
+	Lanes bool
+	// VUMeter draws a meter of the total velocity of the playing notes along the right edge, with a falling peak
+	VUMeter bool
+	// HSVPalette colors tracks with evenly spaced hues when there are more tracks than default colors
+	HSVPalette bool
+	// PaletteColors is the number of hues in the HSV palette, defaults to one per track
+	PaletteColors int
+	// Breakpoints are measures where playback pauses until space is pressed
+	Breakpoints []int
+	// ChannelLabels draws a legend of each active channel's color and General MIDI instrument
+	ChannelLabels bool
+	// CornerRadius rounds the corners of the note rects by this many pixels, 0 keeps them square
+	CornerRadius float64
+	// LoopStart and LoopEnd are the measures looped over, from LoopStart up to but not including LoopEnd.
+	// There's no loop unless LoopEnd is after LoopStart
+	LoopStart int
+	LoopEnd   int
+	// NoteRangeLow and NoteRangeHigh are the lowest and highest notes displayed, overriding the range from the config's
+	// normalize. Notes outside of the range are hidden. The range is only used when NoteRangeHigh is above NoteRangeLow
+	NoteRangeLow  int
+	NoteRangeHigh int
+	// FadeFinished fades out rect notes over this many beats after their note off, then stops drawing them.
+	// 0 keeps drawing finished notes
+	FadeFinished float64
+	// AccentThreshold tags notes whose velocity is more than this above the track's running average as accents,
+	// drawn taller for a moment at their note on. 0 disables accents
+	AccentThreshold int
+	// MinNoteBeats is the minimum length in beats of notes, shorter notes such as zero length ones are lengthened to it
+	// so they play for long enough to be seen. Defaults to 1/64 of a beat
+	MinNoteBeats float64
+	// PixelsPerBeat is how many pixels the scrolling notes move per beat, so the scroll speed is the same
+	// regardless of the tempo and ppqn. 0 scrolls a pixel per tick, stretched for quiet rect notes
+	PixelsPerBeat float64
+	// Ties draws a line between rect notes of a track where one starts as the previous one ends, showing legato phrases
+	Ties bool
+	// Echoes is the number of fading copies drawn behind playing rect notes at their recent positions, 0 disables them
+	Echoes int
+	// EchoDecay is multiplied into the alpha of each echo, from 0 to 1. Defaults to 0.5
+	EchoDecay float64
+	// MinNoteSize is the minimum width and height in pixels notes are drawn at, so very short notes stay visible
+	MinNoteSize float64
+	// StrokeWidth is the width in pixels of the outlines of notes that aren't playing, scaled on high-DPI displays. Defaults to 1
+	StrokeWidth float64
+	// Mirror also draws every note reflected across the horizontal midline
+	Mirror bool
+	// Title is the window title, defaults to "midivis"
+	Title string
+	// TitlePosition appends the current measure and time to the window title, updated once a second
+	TitlePosition bool
+	// DemoIdle is the number of seconds without input before the demo mode switches note types and skips ahead,
+	// repeating until there's input again. 0 disables the demo mode
+	DemoIdle float64
+	// ExportDir writes every frame to a numbered png in this directory instead of playing the audio,
+	// frames are 1/FPS of a second apart unless GridSnap is set
+	ExportDir string
+	// GridSnap exports exactly this many frames per beat, so the frames loop seamlessly on beats
+	GridSnap int
+	// Gap is the vertical space in pixels between notes of adjacent pitches
+	Gap float64
+	// HUD draws the measure:beat:tick, tempo, time and number of playing notes in the bottom left corner,
+	// toggled with H
+	HUD bool
+	// MeasureNumbers draws the number of each measure along the top of the screen, scrolling with the notes
+	MeasureNumbers bool
+	// Seed seeds the random numbers of the color jitter and camera shake, 0 picks a random seed
+	Seed int64
+	// ColorJitter randomly shifts the hue and brightness of each note's color by up to this amount, from 0 (flat colors) to 1
+	ColorJitter float64
+	// OnNoteEvent is called when the playhead crosses a note's on or off, kind is NoteEventOn or NoteEventOff.
+	// It's called from the update loop, so it should return quickly
+	OnNoteEvent func(n Note, kind string)
+	// OSCAddress is the host:port to send /note/on and /note/off OSC messages to over UDP, with the pitch,
+	// velocity and channel of the note as int arguments
+	OSCAddress string
+	// AudioStart is how many seconds into the audio file the midi starts, skipping silence or a count-in at its start
+	AudioStart float64
+	// Width and Height are the logical size of the screen in pixels, e.g. 1080x1920 for vertical video. Default to 1024x768
+	Width  int
+	Height int
+	// Stretch stretches the screen over the whole window when the window's aspect ratio differs,
+	// instead of letterboxing it
+	Stretch bool
+	// TargetFPS enables adaptive quality, lowering quality while the actual FPS is below it. 0 disables it
+	TargetFPS float64
+}
+
+// Sample formats the audio can be decoded to
+const (
+	// AudioFormatF32 decodes to 32-bit float samples
+	AudioFormatF32 = "f32"
+	// AudioFormatS16 decodes to 16-bit integer samples, lighter on constrained hardware
+	AudioFormatS16 = "s16"
+)
+
+// Visualizer renders midi tracks in a window, synced to an audio file
+type Visualizer struct {
+	opts      Options
+	tracks    []*Track
+	drawHooks []DrawHook
+}
+
+// DrawHook draws a custom overlay, called at the end of every frame after the notes and built-in overlays.
+// The screen is in device pixels: the logical width x height screen scaled by g.Scale() on high-DPI displays
+type DrawHook func(screen *ebiten.Image, g *Game)
+
+// New creates a Visualizer, add tracks to it with AddTrack or LoadFile then start it with Run
+func New(opts Options) *Visualizer {
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	if opts.Config == nil {
+		opts.Config = NewRenderConfig()
+	}
+	if opts.FPS <= 0 {
+		opts.FPS = 60
+	}
+	if opts.StrokeWidth <= 0 {
+		opts.StrokeWidth = 1
+	}
+	if opts.BlurStrength == 0 {
+		opts.BlurStrength = 1
+	}
+	if opts.EchoDecay <= 0 {
+		opts.EchoDecay = 0.5
+	}
+	if opts.Title == "" {
+		opts.Title = "midivis"
+	}
+	if opts.Width <= 0 || opts.Height <= 0 {
+		opts.Width, opts.Height = defaultWidth, defaultHeight
+	}
+
+	return &Visualizer{
+		opts:   opts,
+		tracks: []*Track{},
+	}
+}
+
+// AddDrawHook registers a hook to draw a custom overlay every frame, hooks are called in the order they're added
+func (v *Visualizer) AddDrawHook(hook DrawHook) {
+	v.drawHooks = append(v.drawHooks, hook)
+}
+
+// AddTrack adds a track to render. Its name is matched against the config's file patterns
+func (v *Visualizer) AddTrack(t *Track) {
+	v.tracks = append(v.tracks, t)
+}
+
+// LoadFile parses a midi file and adds its tracks, named after the file
+func (v *Visualizer) LoadFile(fileName string) error {
+	tracks, err := v.parseFile(v.opts.FS, fileName)
+	if err != nil {
+		return err
+	}
+	for _, track := range tracks {
+		v.AddTrack(track)
+	}
+
+	return nil
+}
+
+// LoadCompareFile parses a midi file to compare against the other tracks, e.g. a reference for a transcription.
+// Its notes are drawn as faint rects in a contrasting color on top of the other tracks, so the differences stand out.
+// The file is read from the OS's file system and is expected to have the same ppqn as the other tracks
+func (v *Visualizer) LoadCompareFile(fileName string) error {
+	tracks, err := v.parseFile(nil, fileName)
+	if err != nil {
+		return err
+	}
+	for _, track := range tracks {
+		track.compare = true
+		v.AddTrack(track)
+	}
+
+	return nil
+}
+
+// LoadFiles parses the midi files concurrently and adds their tracks in the order of fileNames.
+// The returned errors are in the same order, nil for the files that loaded
+func (v *Visualizer) LoadFiles(fileNames []string) []error {
+	fileTracks := make([][]*Track, len(fileNames))
+	errs := make([]error, len(fileNames))
+
+	// limit the number of files parsed at once to the number of CPUs
+	workers := make(chan struct{}, runtime.NumCPU())
+	var wg sync.WaitGroup
+	for i, fileName := range fileNames {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+			fileTracks[i], errs[i] = v.parseFile(v.opts.FS, fileName)
+		}()
+	}
+	wg.Wait()
+
+	for _, tracks := range fileTracks {
+		for _, track := range tracks {
+			v.AddTrack(track)
+		}
+	}
+
+	return errs
+}
+
+// parseFile parses a midi file of fsys into tracks named after the file, a nil fsys reads from the OS's file system.
+// Each track chunk of a format 1 file becomes its own track, chunks without notes like the tempo track are left out
+func (v *Visualizer) parseFile(fsys fs.FS, fileName string) ([]*Track, error) {
+	midiTracks, err := parseMidiFile(v.opts.Logger, fsys, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	// name compressed tracks like their uncompressed file so they match the same note types and config
+	trackName := strings.TrimSuffix(path.Base(fileName), ".gz")
+	tracks := []*Track{}
+	for _, midiTrack := range midiTracks {
+		if len(midiTracks) > 1 && !midiTrack.hasNotes() {
+			continue
+		}
+		tracks = append(tracks, midiTrack.ToTrack(v.opts.Logger, trackName, v.opts))
+	}
+	return tracks, nil
+}
+
+// openFile opens a file of fsys, or of the OS's file system when fsys is nil
+func openFile(fsys fs.FS, name string) (fs.File, error) {
+	if fsys == nil {
+		return os.Open(name)
+	}
+	return fsys.Open(name)
+}
+
+// Run opens the window and renders the tracks until the window is closed or Escape is pressed
+func (v *Visualizer) Run() error {
+	if len(v.tracks) == 0 {
+		return fmt.Errorf("no tracks to render")
+	}
+
+	game, err := newGame(v.tracks, v.opts)
+	if err != nil {
+		return err
+	}
+	game.drawHooks = v.drawHooks
+
+	ebiten.SetWindowSize(game.width, game.height)
+	// resizing letterboxes the screen, or stretches it with Stretch
+	ebiten.SetWindowResizingMode(ebiten.WindowResizingModeEnabled)
+	ebiten.SetTPS(v.opts.FPS)
+	ebiten.SetWindowTitle(v.opts.Title)
+
+	if v.opts.ExportDir != "" {
+		if err := os.MkdirAll(v.opts.ExportDir, 0755); err != nil {
+			return err
+		}
+	} else {
+		// skip the audio's lead-in so the midi's start lines up with AudioStart
+		if err := game.seekToTime(0); err != nil {
+			return err
+		}
+		game.playAudio()
+	}
+
+	return ebiten.RunGame(game)
+}
+
+// Colors of the tracks, cycled through when there are more tracks than colors
+var defaultPalette = []color.RGBA{
+	colornames.Red,
+	colornames.Blue,
+	colornames.Green,
+	colornames.Yellow,
+	colornames.Purple,
+	colornames.White,
+}
+
+// compareColor and compareZ draw the notes of compared files faintly on top of the other tracks
+var compareColor = dimColor(colornames.Magenta, 0.5)
+
+const compareZ = 100
+
+// hsvPalette returns n colors with evenly spaced hues at full saturation and value
+func hsvPalette(n int) []color.RGBA {
+	palette := make([]color.RGBA, n)
+	for i := range palette {
+		palette[i] = hsvToRGBA(float64(i)/float64(n)*360, 1, 1)
+	}
+
+	return palette
+}
+
+// rgbaToHSV converts a color to a hue in degrees and saturation and value between 0 and 1, ignoring alpha
+func rgbaToHSV(c color.RGBA) (h, s, v float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	v = max(r, g, b)
+	delta := v - min(r, g, b)
+	if v > 0 {
+		s = delta / v
+	}
+	if delta == 0 {
+		return 0, s, v
+	}
+
+	switch v {
+	case r:
+		h = 60 * math.Mod((g-b)/delta, 6)
+	case g:
+		h = 60 * ((b-r)/delta + 2)
+	default:
+		h = 60 * ((r-g)/delta + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+
+	return h, s, v
+}
+
+// Max hue shift in degrees and value change of jitterColor at an amount of 1
+const (
+	maxHueJitter   = 30
+	maxValueJitter = 0.5
+)
+
+// jitterColor randomly shifts the hue and brightness of the color, amount scales the shift from 0 (none) to 1
+func jitterColor(c color.RGBA, amount float64, rng *rand.Rand) color.RGBA {
+	h, s, v := rgbaToHSV(c)
+	h = math.Mod(h+(rng.Float64()*2-1)*amount*maxHueJitter+360, 360)
+	v = min(max(v*(1+(rng.Float64()*2-1)*amount*maxValueJitter), 0), 1)
+
+	jittered := hsvToRGBA(h, s, v)
+	jittered.A = c.A
+	return jittered
+}
+
+// hsvToRGBA converts a hue in degrees and saturation and value between 0 and 1 to an opaque color
+func hsvToRGBA(h, s, v float64) color.RGBA {
+	c := v * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := v - c
+
+	var r, g, b float64
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+
+	return color.RGBA{
+		R: uint8(math.Round((r + m) * 255)),
+		G: uint8(math.Round((g + m) * 255)),
+		B: uint8(math.Round((b + m) * 255)),
+		A: 0xff,
+	}
+}
+
+// newGame sets up the renderables, shaders and audio player for the tracks
+func newGame(tracks []*Track, opts Options) (*Game, error) {
+	logger := opts.Logger
+	config := opts.Config
+
+	// the renderers lay out relative to the screen size, so other aspect ratios reposition rather than squish
+	width, height := opts.Width, opts.Height
+
+	// Use noteTopBottomPaddingPixels to adjust the padding at the top and bottom of screen for notes
+	const noteTopBottomPaddingPixels = 50
+
+	// Use Normalize and/or noteMin/noteMax to adjust the range of notes displayed
+	noteMin := 0
+	noteMax := 127
+	allNotes := make([]Note, 0)
+	for _, t := range tracks {
+		if config.forFile(t.name).includeInNormalize() {
+			allNotes = append(allNotes, t.notes...)
+		}
+	}
+	if config.Normalize && len(allNotes) > 0 {
+
+		sort.Slice(allNotes, func(i, j int) bool {
+			return allNotes[i].num < allNotes[j].num
+		})
+
+		logger.Debug("Sorted Notes:")
+		for _, note := range allNotes {
+			logger.Debug("Note", "num", note.num, "on", note.on, "off", note.off, "vel", note.vel)
+		}
+
+		noteMin = allNotes[0].num
+		noteMax = allNotes[len(allNotes)-1].num
+	}
+
+	// a fixed range keeps the same framing across songs, notes outside of it are hidden
+	hasNoteRange := opts.NoteRangeHigh > opts.NoteRangeLow
+	if hasNoteRange {
+		noteMin = opts.NoteRangeLow
+		noteMax = opts.NoteRangeHigh
+	}
+
+	// files of a single pitch have no range to spread over
+	noteHeight := (height - noteTopBottomPaddingPixels*2) / max(noteMax-noteMin, 1)
+
+	songEndTick := 0
+	for _, t := range tracks {
+		for _, note := range t.notes {
+			songEndTick = max(songEndTick, note.off)
+		}
+	}
+
+	// Use xTranslate to adjust the horizontal translation of the notes (e.g. where the note-on should be occur)
+	xTranslate := float64(width / 2)
+
+	// Setup audio player
+	isS16 := false
+	switch opts.AudioFormat {
+	case "", AudioFormatF32:
+	case AudioFormatS16:
+		isS16 = true
+	default:
+		return nil, fmt.Errorf("unknown audio format %q, must be %s or %s", opts.AudioFormat, AudioFormatF32, AudioFormatS16)
+	}
+
+	// without audio the visuals are timed by the tick clock alone, like after the audio ends
+	var s audioStream
+	audioFile, err := openFile(opts.AudioFS, opts.AudioFile)
+	if err == nil {
+		s, err = decodeAudio(audioFile, opts.AudioFile, isS16)
+	}
+	if errors.Is(err, errUnsupportedAudio) {
+		return nil, err
+	}
+	if err != nil {
+		logger.Warn("Running without audio", "audioFile", opts.AudioFile, "err", err)
+	}
+
+	var waveform *Waveform
+	if opts.Waveform && s != nil {
+		if isS16 {
+			waveform, err = NewWaveformS16(s, s.Length(), s.SampleRate(), width)
+		} else {
+			waveform, err = NewWaveform(s, s.Length(), s.SampleRate(), width)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var spectrum *Spectrum
+	if opts.Spectrum && s != nil {
+		// the spectrum reads its own stream so seeking it doesn't affect playback
+		spectrumFile, err := openFile(opts.AudioFS, opts.AudioFile)
+		if err != nil {
+			return nil, err
+		}
+		spectrumStream, err := decodeAudio(spectrumFile, opts.AudioFile, false)
+		if err != nil {
+			return nil, err
+		}
+		spectrum = NewSpectrum(spectrumStream, spectrumStream.SampleRate())
+	}
+
+	var minimap *Minimap
+	if opts.Minimap {
+		minimap = &Minimap{}
+	}
+
+	var vuMeter *VUMeter
+	if opts.VUMeter {
+		vuMeter = &VUMeter{}
+	}
+
+	var osc *oscClient
+	if opts.OSCAddress != "" {
+		osc, err = newOSCClient(opts.OSCAddress)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var p *audio.Player
+	if s != nil {
+		// the stream isn't resampled, so the context has to run at its sample rate for the audio to play at the right speed.
+		// The decoder always outputs stereo, mono files are duplicated into both channels
+		logger.Debug("Audio sample rate", "sampleRate", s.SampleRate())
+		audioContext := audio.NewContext(s.SampleRate())
+		if isS16 {
+			p, err = audioContext.NewPlayer(s)
+		} else {
+			p, err = audioContext.NewPlayerF32(s)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	colorsToUse := defaultPalette
+	if opts.HSVPalette && len(tracks) > len(defaultPalette) {
+		paletteColors := opts.PaletteColors
+		if paletteColors <= 0 {
+			paletteColors = len(tracks)
+		}
+		colorsToUse = hsvPalette(paletteColors)
+	}
+
+	// each track gets an equal slice of the screen, top to bottom, so tracks with overlapping pitches don't collide
+	var lanes []noteLane
+	if opts.Lanes {
+		lanes = make([]noteLane, len(tracks))
+		laneHeight := float32(height) / float32(len(tracks))
+		for i := range lanes {
+			lanes[i] = noteLane{top: float32(i) * laneHeight, height: laneHeight}
+		}
+	}
+
+	notes := make([]Renderable, 0)
+	legend := make([]legendEntry, 0)
+	for trackIndex, t := range tracks {
+		fileConfig := config.forFile(t.name)
+
+		typeToUse, ok := opts.TypeMapping.noteType(t.name)
+		if !ok {
+			typeToUse, ok = fileNameToType[t.name]
+		}
+		if fileConfig != nil && fileConfig.Type != "" {
+			typeToUse = fileConfig.noteType
+		} else if !ok {
+			logger.Info("Using default note type", "trackName", t.name)
+			typeToUse = NoteTypeRect
+		}
+
+		chosenColor := colorsToUse[trackIndex%len(colorsToUse)]
+		if fileConfig != nil && fileConfig.color != nil {
+			chosenColor = *fileConfig.color
+		}
+
+		z := noteTypeZ[typeToUse]
+		if fileConfig != nil && fileConfig.Z != nil {
+			z = *fileConfig.Z
+		}
+
+		if t.compare {
+			typeToUse = NoteTypeRect
+			chosenColor = compareColor
+			z = compareZ
+		}
+
+		activeChannels := map[int]bool{}
+		trackNotes := make([]Renderable, 0, len(t.notes))
+		for noteIndex, note := range t.notes {
+			if !fileConfig.includesChannel(note.channel) {
+				continue
+			}
+			if hasNoteRange && (note.num < noteMin || note.num > noteMax) {
+				continue
+			}
+			activeChannels[note.channel] = true
+			note.lane = trackIndex
+
+			noteColor := &chosenColor
+			if pitchColor, ok := config.pitchColor(note.num); ok && !t.compare {
+				noteColor = &pitchColor
+			} else if opts.ColorJitter > 0 && !t.compare {
+				jittered := jitterColor(chosenColor, opts.ColorJitter, rng)
+				noteColor = &jittered
+			}
+			noteType, noteZ := typeToUse, z
+			if channelType, ok := fileConfig.channelNoteType(note.channel); ok && !t.compare {
+				noteType = channelType
+				if fileConfig.Z == nil {
+					noteZ = noteTypeZ[channelType]
+				}
+			}
+			trackNotes = append(trackNotes, newRenderable(noteType, note, noteZ, noteColor, noteIndex))
+		}
+		// the track's note types can be more than one when channels have their own
+		trackNotes = setupTrackNotes(trackNotes, fileConfig, opts.Ties)
+		if lanes != nil && len(trackNotes) > 0 {
+			lanes[trackIndex].noteMin, lanes[trackIndex].noteMax = 127, 0
+			for _, r := range trackNotes {
+				for _, n := range renderedNotes(r) {
+					lanes[trackIndex].noteMin = min(lanes[trackIndex].noteMin, n.num)
+					lanes[trackIndex].noteMax = max(lanes[trackIndex].noteMax, n.num)
+				}
+			}
+		}
+		notes = append(notes, trackNotes...)
+
+		for channel := 0; channel < 16; channel++ {
+			if activeChannels[channel] {
+				legend = append(legend, legendEntry{track: t, channel: channel, color: chosenColor})
+			}
+		}
+
+		// kind of dumb to sort here but let's do it anyways for now
+		sort.Slice(notes, func(i, j int) bool {
+			return notes[i].GetZ() < notes[j].GetZ()
+		})
+	}
+
+	shader, err := ebiten.NewShader(radialblur_kage)
+	if err != nil {
+		return nil, err
+	}
+	radialBlurShaderOpts := &ebiten.DrawRectShaderOptions{}
+	radialBlurShaderOpts.Uniforms = map[string]any{
+		"Time":     0,
+		"Cursor":   []float32{float32(0), float32(0)},
+		"Center":   []float32{float32(width / 2), float32(height / 2)},
+		"Strength": float32(opts.BlurStrength),
+	}
+
+	colormodShader, err := ebiten.NewShader(colormod_kage)
+	if err != nil {
+		return nil, err
+	}
+
+	radialGradientShader, err := ebiten.NewShader(radialgradient_kage)
+	if err != nil {
+		return nil, err
+	}
+
+	radialGradientShaderOpts := &ebiten.DrawRectShaderOptions{}
+	radialGradientShaderOpts.Uniforms = map[string]interface{}{
+		"PctShow": 0,
+	}
+
+	game := &Game{
+		width:  width,
+		height: height,
+
+		currentTick:      0,
+		playbackRate:     1,
+		elapsedDeltaTime: 0,
+		playerMeasure:    0,
+		// Assuming all tracks are the same ppqn...
+		ppqn:                       int(tracks[0].ppqn),
+		tempoMap:                   songTempoMap(tracks, int(tracks[0].ppqn)),
+		tracks:                     tracks,
+		notes:                      notes,
+		noteIndex:                  newNoteIndex(notes),
+		noteMin:                    noteMin,
+		noteHeight:                 noteHeight,
+		noteTopBottomPaddingPixels: noteTopBottomPaddingPixels,
+		lanes:                      lanes,
+		xTranslate:                 xTranslate,
+		songEndTick:                songEndTick,
+
+		shader:               shader,
+		radialBlurShaderOpts: radialBlurShaderOpts,
+
+		colormodShader: colormodShader,
+
+		radialGradientShader:     radialGradientShader,
+		radialGradientShaderOpts: radialGradientShaderOpts,
+
+		legend: legend,
+
+		hud:            opts.HUD,
+		timeSignatures: trackTimeSignatures(tracks),
+
+		player:   p,
+		waveform: waveform,
+		minimap:  minimap,
+		spectrum: spectrum,
+		vuMeter:  vuMeter,
+		rng:      rng,
+
+		noteEvents: newNoteEvents(notes),
+		osc:        osc,
+		// the notes on tick 0 haven't been played up to
+		seeked: true,
+
+		loopStart: opts.LoopStart,
+		loopEnd:   opts.LoopEnd,
+
+		opts: opts,
+
+		// updated from the monitor in Layout
+		scale: 1,
+	}
+
+	return game, nil
+}
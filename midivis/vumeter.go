@@ -0,0 +1,82 @@
+package midivis
+
+import (
+	"image/color"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+	"golang.org/x/image/colornames"
+)
+
+// Size and margin in pixels of the VU meter, drawn along the right edge of the screen
+const (
+	vuMeterWidth  = 12
+	vuMeterHeight = 256
+	vuMeterMargin = 8
+)
+
+const (
+	// vuMeterFullScale is the number of full velocity notes playing at once that fill the meter
+	vuMeterFullScale = 8
+	// vuMeterSmoothing is how far the level moves towards the playing notes' velocity every update
+	vuMeterSmoothing = 0.3
+	// vuMeterPeakHold is how many seconds the peak is held before it starts falling
+	vuMeterPeakHold = 0.5
+	// vuMeterPeakDecay is multiplied into the peak every update after it's held
+	vuMeterPeakDecay = 0.97
+)
+
+// VUMeter is a bar showing the total velocity of the playing notes, with a falling peak indicator
+type VUMeter struct {
+	// level and peak are from 0 to 1 of the meter's height
+	level float64
+	peak  float64
+	// peakUpdates is the number of updates since the peak was last raised
+	peakUpdates int
+}
+
+// Update smooths the level towards the velocity of the playing notes and holds its peak
+func (m *VUMeter) Update(g *Game) error {
+	target := min(g.playingActivity()/vuMeterFullScale, 1)
+	m.level += (target - m.level) * vuMeterSmoothing
+
+	m.peakUpdates++
+	if m.level >= m.peak {
+		m.peak = m.level
+		m.peakUpdates = 0
+	} else if float64(m.peakUpdates) > vuMeterPeakHold*float64(g.opts.FPS) {
+		m.peak = max(m.peak*vuMeterPeakDecay, m.level)
+	}
+
+	return nil
+}
+
+// Draw draws the level as a bar filling up from the bottom, with a line at the peak
+func (m *VUMeter) Draw(screen *ebiten.Image, g *Game) {
+	x0 := float32(g.width - vuMeterWidth - vuMeterMargin)
+	y0 := float32(g.height-vuMeterHeight) / 2
+
+	vector.DrawFilledRect(screen, x0*g.scale, y0*g.scale, vuMeterWidth*g.scale, vuMeterHeight*g.scale, color.RGBA{0, 0, 0, 0x99}, false)
+
+	levelHeight := float32(m.level) * vuMeterHeight
+	vector.DrawFilledRect(screen, x0*g.scale, (y0+vuMeterHeight-levelHeight)*g.scale, vuMeterWidth*g.scale, levelHeight*g.scale, vuMeterColor(m.level), false)
+
+	peakY := y0 + vuMeterHeight - float32(m.peak)*vuMeterHeight
+	vector.StrokeLine(screen, x0*g.scale, peakY*g.scale, (x0+vuMeterWidth)*g.scale, peakY*g.scale, 2*g.scale, vuMeterColor(m.peak), false)
+}
+
+// vuMeterColor fades from green to yellow to red as the level goes from 0 to 1
+func vuMeterColor(level float64) color.RGBA {
+	if level < 0.5 {
+		return lerpRGBA(colornames.Lime, colornames.Yellow, level*2)
+	}
+	return lerpRGBA(colornames.Yellow, colornames.Red, (level-0.5)*2)
+}
+
+// lerpRGBA linearly interpolates from a to b, t is from 0 to 1
+func lerpRGBA(a, b color.RGBA, t float64) color.RGBA {
+	lerp := func(a, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*t)
+	}
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), lerp(a.A, b.A)}
+}
@@ -0,0 +1,135 @@
+package midivis
+
+import (
+	"encoding/binary"
+	"image/color"
+	"io"
+	"math"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+	"golang.org/x/image/colornames"
+)
+
+// waveformHeight is the height in pixels of the waveform strip along the bottom of the screen
+const waveformHeight = 48
+
+// bytesPerF32Frame and bytesPerS16Frame are the sizes of one stereo frame of float32 and int16 decoded streams
+const (
+	bytesPerF32Frame = 8
+	bytesPerS16Frame = 4
+)
+
+// Waveform is the amplitude envelope of the audio, drawn as a strip along the bottom of the screen
+type Waveform struct {
+	// peaks holds the peak amplitude (0 to 1) of the audio for each column of pixels
+	peaks    []float32
+	duration time.Duration
+	// image is the cached waveform, rendered on the first draw
+	image *ebiten.Image
+}
+
+// NewWaveform reads the whole float32 decoded stream to compute the peak amplitude for each of columns,
+// then seeks the stream back to the start so it can still be played
+func NewWaveform(stream io.ReadSeeker, length int64, sampleRate int, columns int) (*Waveform, error) {
+	return newWaveform(stream, length, sampleRate, columns, bytesPerF32Frame, func(frame []byte) (float32, float32) {
+		left := math.Float32frombits(binary.LittleEndian.Uint32(frame))
+		right := math.Float32frombits(binary.LittleEndian.Uint32(frame[4:]))
+		return left, right
+	})
+}
+
+// NewWaveformS16 is NewWaveform for int16 decoded streams
+func NewWaveformS16(stream io.ReadSeeker, length int64, sampleRate int, columns int) (*Waveform, error) {
+	return newWaveform(stream, length, sampleRate, columns, bytesPerS16Frame, func(frame []byte) (float32, float32) {
+		left := float32(int16(binary.LittleEndian.Uint16(frame))) / math.MaxInt16
+		right := float32(int16(binary.LittleEndian.Uint16(frame[2:]))) / math.MaxInt16
+		return left, right
+	})
+}
+
+// newWaveform computes the peaks of a stream of bytesPerFrame sized stereo frames, decoded by samples
+func newWaveform(stream io.ReadSeeker, length int64, sampleRate int, columns int, bytesPerFrame int, samples func(frame []byte) (float32, float32)) (*Waveform, error) {
+	totalFrames := length / int64(bytesPerFrame)
+	framesPerColumn := max(totalFrames/int64(columns), 1)
+
+	peaks := make([]float32, columns)
+	buf := make([]byte, 4096*bytesPerFrame)
+	frame := int64(0)
+	for {
+		n, err := io.ReadFull(stream, buf)
+		for i := 0; i+bytesPerFrame <= n; i += bytesPerFrame {
+			left, right := samples(buf[i:])
+			amplitude := max(float32(math.Abs(float64(left))), float32(math.Abs(float64(right))))
+
+			column := min(int(frame/framesPerColumn), columns-1)
+			peaks[column] = min(max(peaks[column], amplitude), 1)
+			frame++
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := stream.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	seconds := float64(totalFrames) / float64(sampleRate)
+	return &Waveform{
+		peaks:    peaks,
+		duration: time.Duration(seconds * float64(time.Second)),
+	}, nil
+}
+
+// render draws the peaks into the cached image, centered vertically in the strip
+func (w *Waveform) render() {
+	w.image = ebiten.NewImage(len(w.peaks), waveformHeight)
+	w.image.Fill(color.RGBA{0, 0, 0, 0x99})
+	for x, peak := range w.peaks {
+		peakHeight := max(peak*waveformHeight, 1)
+		y := (waveformHeight - peakHeight) / 2
+		vector.DrawFilledRect(w.image, float32(x), y, 1, peakHeight, colornames.Lightgray, false)
+	}
+}
+
+// Draw draws the waveform strip and the playhead on the screen
+func (w *Waveform) Draw(screen *ebiten.Image, g *Game) {
+	if w.image == nil {
+		w.render()
+	}
+
+	stripY := float32(g.height - waveformHeight)
+	opts := &ebiten.DrawImageOptions{}
+	opts.GeoM.Scale(float64(g.width)/float64(len(w.peaks)), 1)
+	opts.GeoM.Translate(0, float64(stripY))
+	opts.GeoM.Scale(float64(g.scale), float64(g.scale))
+	screen.DrawImage(w.image, opts)
+
+	// the playhead follows the visuals' clock, which keeps going on seeks and after the audio ends, offset into the audio file
+	position := time.Duration(g.tempoMap.tickToSeconds(g.elapsedDeltaTime)*float64(time.Second)) + g.audioStart()
+	playheadX := float32(position) / float32(w.duration) * float32(g.width)
+	vector.DrawFilledRect(screen, playheadX*g.scale, stripY*g.scale, 2*g.scale, waveformHeight*g.scale, colornames.Red, false)
+}
+
+// Update seeks to the clicked position when the waveform strip is clicked
+func (w *Waveform) Update(g *Game) error {
+	if !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		return nil
+	}
+
+	cx, cy := g.cursorPosition()
+	if cy < float32(g.height-waveformHeight) {
+		return nil
+	}
+
+	pct := min(max(cx/float32(g.width), 0), 1)
+	// the waveform covers the whole audio file, including the lead-in before AudioStart
+	return g.seekToTime(time.Duration(float64(pct)*float64(w.duration)) - g.audioStart())
+}